@@ -8,10 +8,22 @@ import (
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gocql/gocql"
+	"github.com/jezard/joulepersecond-go/aero"
+	"github.com/jezard/joulepersecond-go/climb"
 	"github.com/jezard/joulepersecond-go/conf"
+	"github.com/jezard/joulepersecond-go/dailyload"
+	"github.com/jezard/joulepersecond-go/dataquality"
+	"github.com/jezard/joulepersecond-go/durability"
+	"github.com/jezard/joulepersecond-go/histogram"
+	"github.com/jezard/joulepersecond-go/loadmetric"
+	"github.com/jezard/joulepersecond-go/quadrant"
+	"github.com/jezard/joulepersecond-go/records"
+	"github.com/jezard/joulepersecond-go/torque"
 	"github.com/jezard/joulepersecond-go/types"
 	"github.com/jezard/joulepersecond-go/usersettings"
 	"github.com/jezard/joulepersecond-go/utility"
+	"github.com/jezard/joulepersecond-go/weight"
+	"github.com/jezard/joulepersecond-go/zones"
 	"html/template"
 	"log"
 	"math"
@@ -23,59 +35,87 @@ import (
 	"time"
 )
 
-//row of data struct
+// row of data struct
 type SampleRow struct {
 	Heartrate, Power, Cadence, Lapnumber int
 	Lapstart, Timestamp                  time.Time
 	NewTimestamp                         [3]int //in google chart timeofday format
 }
 
-/* Power Zones:
-Zone 1 Less than 55% of FTPw
-Zone 2 55% to 74% of FTPw
-Zone 3 75% to 89% of FTPw
-Zone 4 90% to 104% of FTPw
-Zone 5 105% to 120% of FTPw
-Zone 6 More than 120% of FTPw
-*/
-
-//data for Critical Power chart (single activity)
+// data for Critical Power chart (single activity)
 type CpRow struct {
 	CpTime [3]int //in google chart timeofday format
 	CpVal  int
 	CpAhr  int
 	CpAcad int
+	HrVal  int //mean maximal heart rate for the duration (not necessarily from the same window as the power)
+	CadVal int //mean maximal cadence for the duration
+}
+
+// lap position within the time series (sample offsets, end exclusive)
+type LapBound struct {
+	Start, End int
+	StartTime  time.Time
+}
+
+// lap to lap comparison, relative to the first lap in the comparison
+type LapComparison struct {
+	Lap, Rep                      int //lap number and repetition number within the comparison
+	Metrics                       types.Metrics
+	PowerFade, NpFade, HeartDrift float64 //percentage change from the first lap
+	PowerHr                       float64 //watts per heart beat
 }
 
-//store various types of information about an activity - 1 record per activity
+// store various types of information about an activity - 1 record per activity
 type ActivityMeta struct {
 	ActivityName, ActivityID                                      string
 	TssOverride, MotivationLevel, PerceivedEffort, StandardRideId int
+	SessionRpe                                                    int //rating of perceived exertion for the whole session (CR-10 scale)
 	IndoorRide, OutdoorRide, Race, Train                          bool
 	OmitFromPC                                                    bool //omit ride from performance chart
 }
 
-//struct for html page template
+// struct for html page template
 type Page struct {
-	Title        string
-	ActivityMeta ActivityMeta
-	Body         []byte
-	Data         []SampleRow
-	LapSummaries []types.Metrics
-	EndSummary   types.Metrics
-	CPM          types.CPMs //Critical power metrics (discrete measurements)
-	CPData       []CpRow    //Time/value pairs for chart
-	HasPower     bool
-	HasHeart     bool
-	HasCadence   bool
-	ZoneData     types.Zones
-	CurThr       int
-	Theme        string
-	Demo         bool
-	Message      string
+	Title          string
+	ActivityMeta   ActivityMeta
+	Body           []byte
+	Data           []SampleRow
+	LapSummaries   []types.Metrics
+	EndSummary     types.Metrics
+	CPM            types.CPMs //Critical power metrics (discrete measurements)
+	CPData         []CpRow    //Time/value pairs for chart
+	LapCompare     []LapComparison
+	LoadLabels     map[string]string
+	Corrections    []dataquality.Correction //data quality corrections and flagged ranges
+	QualityScore   *int                     //nil where the ride was processed before scoring
+	QualityLabels  map[string]string
+	LoadMetric     string //the user's chosen load metric
+	LoadMissing    bool   //the ride doesn't have it (and has no load set by the user)
+	RpeLoad        int    //session RPE load
+	HasPower       bool
+	HasHeart       bool
+	HasCadence     bool
+	ZoneData       types.Zones
+	ZoneLabels     types.ZoneLabels //zones the ride was split into (at the FTP and threshold heart rate current at the time)
+	Histograms     types.Histograms //power, heart rate and cadence distributions
+	Quadrants      quadrant.Summary //pedalling quadrants and the points for the scatter chart
+	QuadrantLabels [4]string
+	TorqueSeries   []float64            //torque each second (Nm)
+	CadenceProfile types.CadenceProfile //cadence and torque in each power zone
+	Climbs         []climb.Climb
+	Altitude       []float64 //for the elevation profile (empty without altitude data)
+	Estimated      []int     //power estimated from speed where it doesn't stand in for the ride's power
+	EstimatedAv    int
+	Records        []records.Record //personal records set on the ride
+	ScopeLabels    map[string]string
+	CurThr         int
+	Theme          string
+	Demo           bool
+	Message        string
 }
 
-//create a data type to represent aggregated sample data
+// create a data type to represent aggregated sample data
 type Samples struct {
 	Power, Hr, Cad, Samplecount, Freewheelcount int
 }
@@ -144,12 +184,19 @@ func ActivityHandler(w http.ResponseWriter, r *http.Request) {
 				if err := session.Query(`DELETE FROM activity_meta WHERE activity_id = ?`, activityId).Exec(); err != nil {
 					log.Printf("3: %v", err)
 				}
+				if err := session.Query(`DELETE FROM user_climb WHERE user_id = ? AND activity_start = ?`, user.Id, lapstart).Exec(); err != nil {
+					log.Printf("3: %v", err)
+				}
+				if err := session.Query(`DELETE FROM user_record WHERE user_id = ? AND activity_start = ?`, user.Id, lapstart).Exec(); err != nil {
+					log.Printf("3: %v", err)
+				}
 				if err := session.Query(`DELETE FROM proc_activity WHERE activity_id = ?`, activityId).Exec(); err != nil {
 					log.Printf("4: %v", err)
 				}
 				if err := session.Query(`DELETE FROM activity_data WHERE activity_id = ?`, activityId).Exec(); err != nil {
 					log.Printf("5: %v", err)
 				}
+				dailyload.Update(user, lapstart)
 
 				//delete the file
 				err = os.Remove(config.UploadDir + filename)
@@ -214,9 +261,11 @@ func ActivityHandler(w http.ResponseWriter, r *http.Request) {
 				activityId := urlparts[2] //get the encoded id
 
 				//get the user set options for the activity...
+				loadChanged := false //the daily load is brought up to date where any of the load values change
 				manualTss, err := strconv.Atoi(r.FormValue("tss"))
 				if err == nil && user.Demo == false {
 					meta.TssOverride = manualTss
+					loadChanged = true
 					//add/update the database with the new values
 					if err := session.Query(`INSERT INTO activity_meta (activity_id, tss_value ) VALUES (?, ?)`,
 						activityId, meta.TssOverride).Exec(); err != nil {
@@ -227,6 +276,7 @@ func ActivityHandler(w http.ResponseWriter, r *http.Request) {
 				motivationLevel, err := strconv.Atoi(r.FormValue("motivation_level"))
 				if err == nil && user.Demo == false {
 					meta.MotivationLevel = motivationLevel
+					loadChanged = true
 					//add/update the database with the new values
 					if err := session.Query(`INSERT INTO activity_meta (activity_id, motivation_level ) VALUES (?, ?)`,
 						activityId, meta.MotivationLevel).Exec(); err != nil {
@@ -237,6 +287,7 @@ func ActivityHandler(w http.ResponseWriter, r *http.Request) {
 				perceivedEffort, err := strconv.Atoi(r.FormValue("perceived_effort"))
 				if err == nil && user.Demo == false {
 					meta.PerceivedEffort = perceivedEffort
+					loadChanged = true
 					//add/update the database with the new values
 					if err := session.Query(`INSERT INTO activity_meta (activity_id, perceived_effort ) VALUES (?, ?)`,
 						activityId, meta.PerceivedEffort).Exec(); err != nil {
@@ -244,6 +295,17 @@ func ActivityHandler(w http.ResponseWriter, r *http.Request) {
 					}
 				}
 
+				sessionRpe, err := strconv.Atoi(r.FormValue("session_rpe"))
+				if err == nil && user.Demo == false {
+					meta.SessionRpe = sessionRpe
+					loadChanged = true
+					//add/update the database with the new values
+					if err := session.Query(`INSERT INTO activity_meta (activity_id, session_rpe ) VALUES (?, ?)`,
+						activityId, meta.SessionRpe).Exec(); err != nil {
+						log.Printf("Location:%v", err)
+					}
+				}
+
 				//standard ride id
 				standardRideId, err := strconv.Atoi(r.FormValue("standard_ride_id"))
 
@@ -322,16 +384,64 @@ func ActivityHandler(w http.ResponseWriter, r *http.Request) {
 					}
 				}
 
+				if loadChanged {
+					var end_summary_json []byte
+					var summary types.Metrics
+					session.Query(`SELECT end_summary_json FROM proc_activity WHERE activity_id = ?`, activityId).Scan(&end_summary_json)
+					json.Unmarshal(end_summary_json, &summary)
+					dailyload.Update(user, summary.StartTime)
+				}
+
 				//get any stored tss value for the activity
-				if err := session.Query(`SELECT activity_id, activity_name, is_indoor, is_outdoor, is_race, is_training, tss_value, motivation_level, perceived_effort, omit_from_pc FROM activity_meta WHERE activity_id = ?`, activityId).Scan(&activityId, &meta.ActivityName, &meta.IndoorRide, &meta.OutdoorRide, &meta.Race, &meta.Train, &meta.TssOverride, &meta.MotivationLevel, &meta.PerceivedEffort, &meta.OmitFromPC); err != nil {
+				if err := session.Query(`SELECT activity_id, activity_name, is_indoor, is_outdoor, is_race, is_training, tss_value, motivation_level, perceived_effort, session_rpe, omit_from_pc FROM activity_meta WHERE activity_id = ?`, activityId).Scan(&activityId, &meta.ActivityName, &meta.IndoorRide, &meta.OutdoorRide, &meta.Race, &meta.Train, &meta.TssOverride, &meta.MotivationLevel, &meta.PerceivedEffort, &meta.SessionRpe, &meta.OmitFromPC); err != nil {
 					//set user tss to 0 if no value held in db for activity
 					meta.TssOverride = 0
 				}
 
-				p := viewActivity(activityId, user, meta)
+				//laps selected for lap to lap comparison (auto selected when none given)
+				if err := r.ParseForm(); err != nil {
+					log.Printf("Location:%v", err)
+				}
+				selectedLaps := make([]int, 0)
+				for _, val := range r.Form["laps[]"] {
+					lapIndex, err := strconv.Atoi(val) //zero based index of the lap in the summary table
+					if err == nil {
+						selectedLaps = append(selectedLaps, lapIndex+1)
+					}
+				}
+
+				p := viewActivity(activityId, user, meta, selectedLaps)
 				t, _ := template.ParseFiles(config.Tpath + "activity.html")
 				t.Execute(w, p)
 			}
+			//virtual elevation for the activity or a range of its laps e.g. /view/ve/ActIviTyiD/token?from-lap=2&to-lap=5 (cda, crr and rho can be given to skip the fit)
+			if noun == "ve" {
+				activityId := urlparts[2]
+				fromLap, err := strconv.Atoi(r.FormValue("from-lap"))
+				if err != nil {
+					fromLap = 0
+				}
+				toLap, err := strconv.Atoi(r.FormValue("to-lap"))
+				if err != nil {
+					toLap = 0
+				}
+				cda, _ := strconv.ParseFloat(r.FormValue("cda"), 64)
+				crr, _ := strconv.ParseFloat(r.FormValue("crr"), 64)
+				rho, _ := strconv.ParseFloat(r.FormValue("rho"), 64)
+
+				result, err := virtualElevation(activityId, user, fromLap, toLap, cda, crr, rho)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				ve_json, err := json.Marshal(result)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(ve_json)
+			}
 			if noun == "range" {
 				message := utility.Dostuff()
 				fmt.Printf("%v", message)
@@ -384,15 +494,15 @@ func getResults(activityId string) []map[string]interface{} {
 	}
 	return alldata
 }
-func saveProcessed(user types.UserSettings, activityId, title string, row_json, power_json, heart_json, cadence_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json []byte, hasPower, hasHeart, hasCadence bool, curFtp, curThr int, activityStart time.Time) {
+func saveProcessed(user types.UserSettings, activityId, title string, row_json, power_json, heart_json, cadence_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, quality_json []byte, hasPower, hasHeart, hasCadence bool, curFtp, curThr, qualityScore int, activityStart time.Time) {
 	cluster := gocql.NewCluster(config.DbHost)
 	cluster.Keyspace = "joulepersecond"
 	cluster.Consistency = gocql.Quorum
 	session, _ := cluster.CreateSession()
 	defer session.Close()
 
-	if err := session.Query(`INSERT INTO proc_activity (activity_id, title, row_json, power_json, heart_json, cadence_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, has_power, has_heart, has_cadence, cur_ftp, cur_thr) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		activityId, title, row_json, power_json, heart_json, cadence_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, hasPower, hasHeart, hasCadence, curFtp, curThr).Exec(); err != nil {
+	if err := session.Query(`INSERT INTO proc_activity (activity_id, title, row_json, power_json, heart_json, cadence_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, quality_json, has_power, has_heart, has_cadence, cur_ftp, cur_thr, quality_score) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		activityId, title, row_json, power_json, heart_json, cadence_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, quality_json, hasPower, hasHeart, hasCadence, curFtp, curThr, qualityScore).Exec(); err != nil {
 		log.Printf("Location:%v", err)
 	}
 	if err := session.Query(`INSERT INTO user_activity (user_id, activity_id, activity_start, end_summary_json, has_power, has_heart) VALUES (?, ?, ?, ?, ?, ?)`,
@@ -401,17 +511,132 @@ func saveProcessed(user types.UserSettings, activityId, title string, row_json,
 	}
 
 }
-func getPreProcessed(activityId string) (title string, row_json, power_json, heart_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json []byte, has_power, has_heart, has_cadence bool, cur_ftp, cur_thr int) {
+
+// save the altitude, distance and estimated power series and the ride's climbs - each climb also goes in the user's climbs table
+func saveRoute(user types.UserSettings, activityId string, altitude_json, distance_json, climb_json, estimated_json []byte, climbs []climb.Climb, activityStart time.Time) {
 	cluster := gocql.NewCluster(config.DbHost)
 	cluster.Keyspace = "joulepersecond"
 	cluster.Consistency = gocql.Quorum
 	session, _ := cluster.CreateSession()
 	defer session.Close()
 
-	if err := session.Query(`SELECT title, row_json, power_json, heart_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, has_power, has_heart, has_cadence, cur_ftp, cur_thr FROM proc_activity WHERE activity_id = ?`, activityId).Scan(&title, &row_json, &power_json, &heart_json, &cp_row_json, &cp_data_json, &lap_summaries_json, &end_summary_json, &has_power, &has_heart, &has_cadence, &cur_ftp, &cur_thr); err != nil {
-		return title, row_json, power_json, heart_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, has_power, has_heart, has_cadence, cur_ftp, cur_thr
+	if err := session.Query(`INSERT INTO proc_activity (activity_id, altitude_json, distance_json, climb_json, estimated_json) VALUES (?, ?, ?, ?, ?)`,
+		activityId, altitude_json, distance_json, climb_json, estimated_json).Exec(); err != nil {
+		log.Printf("Location:%v", err)
+	}
+	//clear out any climbs from an earlier processing of the activity
+	if err := session.Query(`DELETE FROM user_climb WHERE user_id = ? AND activity_start = ?`, user.Id, activityStart).Exec(); err != nil {
+		log.Printf("Location:%v", err)
+	}
+	for i, c := range climbs {
+		c.ActivityId = activityId
+		c.ActivityStart = activityStart
+		climb_json, _ := json.Marshal(c)
+		if err := session.Query(`INSERT INTO user_climb (user_id, activity_start, climb_number, activity_id, climb_json) VALUES (?, ?, ?, ?, ?)`,
+			user.Id, activityStart, i, activityId, climb_json).Exec(); err != nil {
+			log.Printf("Location:%v", err)
+		}
 	}
-	return title, row_json, power_json, heart_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, has_power, has_heart, has_cadence, cur_ftp, cur_thr
+}
+
+// keep the weight the activity was processed with
+func saveWeight(activityId string, activityWeight int) {
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	if err := session.Query(`INSERT INTO activity_meta (activity_id, activity_weight) VALUES (?, ?)`, activityId, activityWeight).Exec(); err != nil {
+		log.Printf("Location:%v", err)
+	}
+}
+
+// the altitude, distance and estimated power series and climbs of a processed activity
+func getRoute(activityId string) (altitude_json, distance_json, climb_json, estimated_json []byte) {
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	if err := session.Query(`SELECT altitude_json, distance_json, climb_json, estimated_json FROM proc_activity WHERE activity_id = ?`, activityId).Scan(&altitude_json, &distance_json, &climb_json, &estimated_json); err != nil {
+		log.Printf("Location:%v", err)
+	}
+	return
+}
+
+// virtual elevation analysis of an activity between two laps (1 based and inclusive, 0 for the whole ride)
+func virtualElevation(activityId string, user types.UserSettings, fromLap, toLap int, cda, crr, rho float64) (result aero.Result, err error) {
+	_, _, power_json, _, _, _, lap_summaries_json, _, _, has_power, _, _, _, _, _ := getPreProcessed(activityId)
+	altitude_json, distance_json, _, _ := getRoute(activityId)
+
+	powerSeries := make([]int, 0)
+	altitudeSeries := make([]float64, 0)
+	distanceSeries := make([]float64, 0)
+	lapSummaries := make([]types.Metrics, 0)
+	json.Unmarshal(power_json, &powerSeries)
+	json.Unmarshal(altitude_json, &altitudeSeries)
+	json.Unmarshal(distance_json, &distanceSeries)
+	json.Unmarshal(lap_summaries_json, &lapSummaries)
+
+	if !has_power || len(altitudeSeries) == 0 || len(distanceSeries) == 0 {
+		return result, errors.New("Virtual elevation needs power, speed (distance) and altitude data.")
+	}
+
+	//laps run back to back so each lap starts where the last one finished
+	start, end := 0, len(powerSeries)
+	if fromLap > 0 || toLap > 0 {
+		if toLap == 0 || toLap > len(lapSummaries) {
+			toLap = len(lapSummaries)
+		}
+		if fromLap < 1 {
+			fromLap = 1
+		}
+		if fromLap > toLap {
+			return result, errors.New("Invalid lap range.")
+		}
+		offset := 0
+		for i, lap := range lapSummaries {
+			if i+1 == fromLap {
+				start = offset
+			}
+			offset += int(lap.Dur.Seconds())
+			if i+1 == toLap {
+				end = offset
+			}
+		}
+	}
+
+	//the rider's weight as recorded with the activity, plus their bike
+	var activity_weight int
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+	session.Query(`SELECT activity_weight FROM activity_meta WHERE activity_id = ?`, activityId).Scan(&activity_weight)
+	if activity_weight == 0 {
+		activity_weight = user.Weight
+	}
+	mass := float64(activity_weight) + aero.BikeWeight(user)
+
+	result = aero.Analyse(powerSeries, aero.Speed(distanceSeries), altitudeSeries, start, end, mass, rho, cda, crr)
+	result.ActivityId = activityId
+	return
+}
+
+func getPreProcessed(activityId string) (title string, row_json, power_json, heart_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, quality_json []byte, has_power, has_heart, has_cadence bool, cur_ftp, cur_thr int, quality_score *int) {
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	if err := session.Query(`SELECT title, row_json, power_json, heart_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, quality_json, has_power, has_heart, has_cadence, cur_ftp, cur_thr, quality_score FROM proc_activity WHERE activity_id = ?`, activityId).Scan(&title, &row_json, &power_json, &heart_json, &cp_row_json, &cp_data_json, &lap_summaries_json, &end_summary_json, &quality_json, &has_power, &has_heart, &has_cadence, &cur_ftp, &cur_thr, &quality_score); err != nil {
+		return title, row_json, power_json, heart_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, quality_json, has_power, has_heart, has_cadence, cur_ftp, cur_thr, quality_score
+	}
+	return title, row_json, power_json, heart_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, quality_json, has_power, has_heart, has_cadence, cur_ftp, cur_thr, quality_score
 
 }
 
@@ -430,6 +655,7 @@ func processActivity(activityId string, user types.UserSettings) {
 	rows := make([]SampleRow, 0)
 	//and lap summary data
 	lapSummaries := make([]types.Metrics, 0)
+	lapBounds := make([]LapBound, 0)
 	//cp data
 	cpRows := make([]CpRow, 0)
 
@@ -441,7 +667,6 @@ func processActivity(activityId string, user types.UserSettings) {
 	var sampletime time.Time         //last iteration's sample time
 	var sampleDistance time.Duration //time duration between this and last iteration's sample time
 	//var timeSubtract time.Duration   //total time to subtract from sample time to give continuous line when using continuous axes
-	var pedalcount int //temporary var storing number of samples with cadence value of 0
 	var ElapsedTime time.Duration
 	hasPower := true
 	hasHeart := true
@@ -451,6 +676,20 @@ func processActivity(activityId string, user types.UserSettings) {
 	heartSeries := make([]int, 0)   //heart rate time series data
 	cadenceSeries := make([]int, 0) //cadence time series data
 
+	//altitude and distance (metres) where the file has them
+	var altitude, distance float64
+	altitudeSeries := make([]float64, 0)
+	distanceSeries := make([]float64, 0)
+
+	//the weight in force on the ride date (from the weight log) - W/kg, energy, climbs and power estimates below all use it
+	rideWeight := float64(user.Weight)
+	if len(data) > 0 {
+		if start, ok := data[0]["lap_start"].(time.Time); ok {
+			rideWeight = weight.ForDate(user, start)
+			user.Weight = int(rideWeight + .5)
+		}
+	}
+
 	//see http://golang.org/pkg/time/#example_Parse
 	const layout = "15:04:05"
 	for _, val := range data {
@@ -459,6 +698,8 @@ func processActivity(activityId string, user types.UserSettings) {
 		row.Heartrate = val["tp_heartrate"].(int)
 		row.Power = val["tp_watts"].(int)
 		row.Cadence = val["tp_cadence"].(int)
+		altitude, _ = val["tp_altitude"].(float64) //optional - zero for files without
+		distance, _ = val["tp_distance"].(float64)
 		row.Lapnumber = val["lap_number"].(int)
 		row.Lapstart = val["lap_start"].(time.Time)
 		//set the activity start time to that of the first lap
@@ -551,6 +792,8 @@ func processActivity(activityId string, user types.UserSettings) {
 						powerSeries = append(powerSeries, row.Power)
 						heartSeries = append(heartSeries, row.Heartrate)
 						cadenceSeries = append(cadenceSeries, row.Cadence)
+						altitudeSeries = append(altitudeSeries, altitude)
+						distanceSeries = append(distanceSeries, distance)
 					}
 				}
 			}
@@ -560,19 +803,8 @@ func processActivity(activityId string, user types.UserSettings) {
 
 		//if a new lap
 		if row.Lapstart != laptime && lap.Samplecount > 0 && !(laptime.IsZero()) {
-			//calculate lap totals
-			lapSummary.Avpower = lap.Power / lap.Samplecount
-			lapSummary.Avheart = lap.Hr / lap.Samplecount
-			lapSummary.Dur = time.Duration(lap.Samplecount) * time.Second
-			pedalcount = (lap.Samplecount - lap.Freewheelcount)
-			if pedalcount > 0 {
-				lapSummary.Avcad = lap.Cad / (lap.Samplecount - lap.Freewheelcount)
-			} else {
-				lapSummary.Avcad = 0
-			}
-
-			//append the summary lap data
-			lapSummaries = append(lapSummaries, lapSummary)
+			//mark the samples added since the lap began (lap totals are calculated once the data is cleaned)
+			lapBounds = append(lapBounds, LapBound{Start: len(powerSeries) - lap.Samplecount, End: len(powerSeries), StartTime: laptime})
 
 			//reset lap
 			laptime = row.Lapstart
@@ -587,6 +819,35 @@ func processActivity(activityId string, user types.UserSettings) {
 		}
 	}
 
+	//mark the final lap
+	if lap.Samplecount > 0 {
+		lapBounds = append(lapBounds, LapBound{Start: len(powerSeries) - lap.Samplecount, End: len(powerSeries), StartTime: laptime})
+	}
+
+	/***
+	* Estimated power - rides without a power meter but with speed (distance) data. Always stored separately, and used in
+	* place of the (empty) power series if the user wants estimated power to count towards their metrics and power curve
+	***/
+	estimatedSeries := make([]int, 0)
+	if maxVal(powerSeries) == 0 {
+		estimatedSeries = aero.Estimate(aero.Speed(distanceSeries), altitudeSeries, rideWeight+aero.BikeWeight(user), aero.CdA(user), aero.Crr(user))
+		if user.UseEstimated && maxVal(estimatedSeries) > 0 {
+			copy(powerSeries, estimatedSeries)
+			endSummary.EstimatedPower = true
+		}
+	}
+
+	/***
+	* Data quality - remove spikes, fill dropouts and flag anything suspect before any metrics are calculated
+	***/
+	quality := dataquality.Clean(powerSeries, heartSeries, cadenceSeries, user.Cleaning)
+	//keep the chart data in step with the cleaned series
+	for i := range rows {
+		rows[i].Power = powerSeries[i]
+		rows[i].Heartrate = heartSeries[i]
+		rows[i].Cadence = cadenceSeries[i]
+	}
+
 	//get the number of samples (these are already processed and are at one second intervals)
 	seriesLen := len(rows)                                               //***would be good to save this data in cassandra***
 	activityDuration := (time.Duration(seriesLen) * time.Second).Hours() //and this
@@ -599,6 +860,8 @@ func processActivity(activityId string, user types.UserSettings) {
 	var maxCpVal int
 	var maxCpHrVal int
 	var maxCpCadVal int
+	var maxHrVal int  //best sustained heart rate
+	var maxCadVal int //best sustained cadence
 	var sumCpVal int
 	var sumHrVal int
 	var sumCadVal int
@@ -632,6 +895,8 @@ func processActivity(activityId string, user types.UserSettings) {
 		if isPreset || i == 1 || i == 2 || i == 3 || i == 4 || i == 5 || i == 10 || i == 20 || i == 30 || i == 60 || i == 5*60 || i == 20*60 || i == 30*60 || i == 60*60 || i == 120*60 || i == 240*60 || i == 360*60 || i == 480*60 || i == 600*60 {
 			//reset max for each duration calculated
 			maxCpVal = 0
+			maxHrVal = 0
+			maxCadVal = 0
 			//this loop determines the point at which to start searching
 			for j := 0; j <= (seriesLen - i); j++ { ///j=0; j<1; j++ ... j=1; j<2; j++ etc
 				//rolling power slice is from start pos to smapling period
@@ -660,6 +925,12 @@ func processActivity(activityId string, user types.UserSettings) {
 					maxCpHrVal = (sumHrVal / i) //calucate the averate heart rate that accompanies the high critical power
 					maxCpCadVal = (sumCadVal / i)
 				}
+				if (sumHrVal / i) > maxHrVal {
+					maxHrVal = (sumHrVal / i)
+				}
+				if (sumCadVal / i) > maxCadVal {
+					maxCadVal = (sumCadVal / i)
+				}
 			}
 			//preset duration vals
 			switch i {
@@ -704,6 +975,8 @@ func processActivity(activityId string, user types.UserSettings) {
 			cpRow.CpVal = maxCpVal
 			cpRow.CpAhr = maxCpHrVal
 			cpRow.CpAcad = maxCpCadVal
+			cpRow.HrVal = maxHrVal
+			cpRow.CadVal = maxCadVal
 			cpRows = append(cpRows, cpRow)
 
 			logVal -= accuracyVal
@@ -715,53 +988,35 @@ func processActivity(activityId string, user types.UserSettings) {
 	//post loop calculations
 	if activity.Samplecount > 0 {
 		//calculate lap totals
-		lapSummary.Avpower = lap.Power / lap.Samplecount
-		lapSummary.Avheart = lap.Hr / lap.Samplecount
-		lapSummary.Dur = time.Duration(lap.Samplecount) * time.Second
-		pedalcount = (lap.Samplecount - lap.Freewheelcount)
-		if pedalcount > 0 {
-			lapSummary.Avcad = lap.Cad / (lap.Samplecount - lap.Freewheelcount)
-		} else {
-			lapSummary.Avcad = 0
-		}
+		for _, bound := range lapBounds {
+			lapSummary = lapMetrics(powerSeries[bound.Start:bound.End], heartSeries[bound.Start:bound.End], cadenceSeries[bound.Start:bound.End], user)
+			lapSummary.StartTime = bound.StartTime
 
-		//append the summary lap data
-		lapSummaries = append(lapSummaries, lapSummary)
+			//append the summary lap data
+			lapSummaries = append(lapSummaries, lapSummary)
+		}
 
 		//calculate totals
-		endSummary.Avpower = activity.Power / activity.Samplecount
-		endSummary.Avheart = activity.Hr / activity.Samplecount
-		pedalcount = (activity.Samplecount - activity.Freewheelcount)
-		if pedalcount > 0 {
-			endSummary.Avcad = activity.Cad / (activity.Samplecount - activity.Freewheelcount)
-		} else {
-			endSummary.Avcad = 0
-		}
+		endSummary.Avpower, endSummary.Avheart, endSummary.Avcad = averages(powerSeries, heartSeries, cadenceSeries)
 	}
 
 	/***
 	* normalised power
 	***/
 
-	var fourthPower float64
-	var thirtySecondSum int
-	var thirtySecondAv float64
-	for i := 30; i < seriesLen; i++ {
-		//reset total
-		thirtySecondSum = 0
-		//get thirty second rolling slice
-		rollingPowerSlice := powerSeries[i-30 : i]
-		for _, val := range rollingPowerSlice {
-			//sum the sliding slice values
-			thirtySecondSum += val
-		}
-		thirtySecondAv = float64(thirtySecondSum / 30)
-		//multply by the power of 4
-		fourthPower += math.Pow(thirtySecondAv, 4)
-	}
-	//normalised power = 4th root of total of 30 second averages divided my number of averages taken (total - 30 to allow for start offset and slice length)
-	normalisedPower := int(math.Pow(fourthPower/float64(seriesLen-30), 0.25)) //4th root is power 1/4 (0.25)
+	normalisedPower := getNormalisedPower(powerSeries)
 	endSummary.Np = normalisedPower
+	endSummary.MaxPower = maxVal(powerSeries)
+	endSummary.MaxHeart = maxVal(heartSeries)
+	endSummary.MaxCad = maxVal(cadenceSeries)
+	if user.Weight > 0 {
+		endSummary.Wkg = utility.Round(float64(endSummary.Avpower)/float64(user.Weight), .5, 2)
+	}
+
+	/***
+	* Variability index, efficiency factor and aerobic decoupling
+	***/
+	endSummary = aerobicMetrics(endSummary, powerSeries, heartSeries)
 
 	/***
 	* Intensity factor
@@ -785,31 +1040,60 @@ func processActivity(activityId string, user types.UserSettings) {
 	* Estimated TSS
 	***/
 	var etssSum int
-	var inc int
+	etssZones := zones.ThresholdHeart(user.Thr)
+	etssWeights := []int{55, 60, 69, 87, 100, 118, 140} //zones 1, 2, 3, 4, 5a, 5b and 5c
 	for _, val := range heartSeries {
-		if val == 0 {
-			inc = 0 //no hr data
-		} else if float64(val) < 0.81*float64(user.Thr) {
-			inc = 55 //zone 1
-		} else if float64(val) > 0.81*float64(user.Thr) && float64(val) <= 0.89*float64(user.Thr) {
-			inc = 60 //zone 2
-		} else if float64(val) > 0.89*float64(user.Thr) && float64(val) <= 0.93*float64(user.Thr) {
-			inc = 69 //zone 3
-		} else if float64(val) > 0.93*float64(user.Thr) && float64(val) <= 0.99*float64(user.Thr) {
-			inc = 87 //zone 4
-		} else if float64(val) > 0.99*float64(user.Thr) && float64(val) <= 1.02*float64(user.Thr) {
-			inc = 100 //zone 5a
-		} else if float64(val) > 1.02*float64(user.Thr) && float64(val) <= 1.06*float64(user.Thr) {
-			inc = 118 //zone 5b
-		} else if float64(val) > 1.06*float64(user.Thr) {
-			inc = 140 //zone 5c
-		}
-		etssSum += inc
+		if val > 0 { //no hr data otherwise
+			etssSum += etssWeights[etssZones.Index(float64(val))]
+		}
 	}
 	if len(heartSeries) > 0 {
 		endSummary.Etss = int(float64(etssSum/len(heartSeries)) * activityDuration)
 	}
 
+	/***
+	* Alternative load metrics (the user chooses which drives their charts)
+	***/
+	endSummary.XPower = loadmetric.XPower(powerSeries)
+	endSummary.Load = loadmetric.All(powerSeries, heartSeries, user)
+	endSummary.Load[loadmetric.Tss] = endSummary.Tss
+
+	/***
+	* Time in zone (stored so that the dashboard and analysis don't need the time series)
+	***/
+	endSummary.TimeInZone = zones.TimeInZone(powerSeries, heartSeries, user, user.Ftp, user.Thr)
+
+	/***
+	* Power, heart rate and cadence distributions (at the user's bin widths)
+	***/
+	endSummary.Histograms = histogram.ForSeries(powerSeries, heartSeries, cadenceSeries, user)
+
+	/***
+	* Pedalling quadrants (around the FTP point)
+	***/
+	endSummary.Quadrants = quadrant.Split(powerSeries, cadenceSeries, user, user.Ftp)
+
+	/***
+	* Torque and the cadence chosen in each power zone
+	***/
+	endSummary.Torque, endSummary.MaxTorque = torque.Summary(powerSeries, cadenceSeries)
+	endSummary.CadenceProfile = torque.Profile(powerSeries, cadenceSeries, user, user.Ftp)
+
+	/***
+	* Durability - mean maximal power after each of the user's work thresholds
+	***/
+	endSummary.Durability = durability.Curves(powerSeries, user)
+
+	/***
+	* Bests for the personal records
+	***/
+	endSummary.Bests = records.Bests(powerSeries)
+
+	/***
+	* Climbs (rides with altitude and distance only)
+	***/
+	climbs := climb.Detect(altitudeSeries, distanceSeries, powerSeries, rideWeight)
+
 	//set page var stuff
 
 	if endSummary.Avpower == 0 {
@@ -869,17 +1153,212 @@ func processActivity(activityId string, user types.UserSettings) {
 	cp_data_json, err := json.Marshal(cpms)          //critical power metrics
 	lap_summaries_json, err := json.Marshal(lapSummaries)
 	end_summary_json, err := json.Marshal(endSummary)
+	quality_json, err := json.Marshal(quality.Corrections) //data quality corrections
 	if err != nil {
 		fmt.Println("error:", err)
 	}
-	saveProcessed(user, activityId, title, row_json, power_json, heart_json, cadence_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, hasPower, hasHeart, hasCadence, user.Ftp, user.Thr, activityStart)
+	altitude_json, err := json.Marshal(altitudeSeries)
+	distance_json, err := json.Marshal(distanceSeries)
+	climb_json, err := json.Marshal(climbs)
+	estimated_json, err := json.Marshal(estimatedSeries)
+	if err != nil {
+		fmt.Println("error:", err)
+	}
+	saveProcessed(user, activityId, title, row_json, power_json, heart_json, cadence_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, quality_json, hasPower, hasHeart, hasCadence, user.Ftp, user.Thr, quality.Score, activityStart)
+	saveRoute(user, activityId, altitude_json, distance_json, climb_json, estimated_json, climbs, activityStart)
+	saveWeight(activityId, user.Weight)
+
+	//new personal records against the user's earlier rides
+	var newRecords []records.Record
+	if hasPower && (!endSummary.EstimatedPower || user.UseEstimated) {
+		newRecords = records.Compare(records.Ride{ActivityId: activityId, ActivityStart: activityStart, Bests: endSummary.Bests}, records.Holders(user, activityStart))
+	}
+	records.Save(user, activityStart, newRecords)
+
+	//bring the daily load up to date from the ride's day
+	dailyload.Update(user, activityStart)
 }
 
 func aggregate() {
 
 }
 
-func viewActivity(activityId string, user types.UserSettings, meta ActivityMeta) (p Page) {
+// normalised power = 4th root of the mean of the 4th powers of the 30 second rolling averages
+func getNormalisedPower(powerSeries []int) int {
+	seriesLen := len(powerSeries)
+	if seriesLen <= 30 {
+		//too short for a rolling 30 second average
+		return 0
+	}
+	var fourthPower float64
+	var thirtySecondSum int
+	var thirtySecondAv float64
+	for i := 30; i < seriesLen; i++ {
+		//reset total
+		thirtySecondSum = 0
+		//get thirty second rolling slice
+		rollingPowerSlice := powerSeries[i-30 : i]
+		for _, val := range rollingPowerSlice {
+			//sum the sliding slice values
+			thirtySecondSum += val
+		}
+		thirtySecondAv = float64(thirtySecondSum / 30)
+		//multply by the power of 4
+		fourthPower += math.Pow(thirtySecondAv, 4)
+	}
+	//divide by number of averages taken (total - 30 to allow for start offset and slice length)
+	return int(math.Pow(fourthPower/float64(seriesLen-30), 0.25)) //4th root is power 1/4 (0.25)
+}
+
+// highest value in a time series
+func maxVal(series []int) (max int) {
+	for _, val := range series {
+		if val > max {
+			max = val
+		}
+	}
+	return
+}
+
+// average power, heart rate and cadence (not counting freewheeling) of a stretch of the time series
+func averages(powerSeries, heartSeries, cadenceSeries []int) (avpower, avheart, avcad int) {
+	var power, hr, cad, pedalcount int
+	for i := range powerSeries {
+		power += powerSeries[i]
+		hr += heartSeries[i]
+		//don't add to the average cadence val when freewheeling
+		if cadenceSeries[i] > 0 {
+			cad += cadenceSeries[i]
+			pedalcount++
+		}
+	}
+	if len(powerSeries) > 0 {
+		avpower = power / len(powerSeries)
+		avheart = hr / len(powerSeries)
+	}
+	if pedalcount > 0 {
+		avcad = cad / pedalcount
+	}
+	return
+}
+
+// calculate the summary metrics for a single lap from the lap's slice of each time series
+func lapMetrics(powerSeries, heartSeries, cadenceSeries []int, user types.UserSettings) (lapSummary types.Metrics) {
+	lapSummary.Avpower, lapSummary.Avheart, lapSummary.Avcad = averages(powerSeries, heartSeries, cadenceSeries)
+	lapSummary.Dur = time.Duration(len(powerSeries)) * time.Second
+	lapSummary.MaxPower = maxVal(powerSeries)
+	lapSummary.MaxHeart = maxVal(heartSeries)
+	lapSummary.MaxCad = maxVal(cadenceSeries)
+
+	if lapSummary.Avpower == 0 {
+		return
+	}
+
+	//short laps (sprints etc) don't have enough samples for a rolling average, so use the average power
+	lapSummary.Np = getNormalisedPower(powerSeries)
+	if lapSummary.Np == 0 {
+		lapSummary.Np = lapSummary.Avpower
+	}
+	if user.Ftp > 0 {
+		intensity := float64(lapSummary.Np) / float64(user.Ftp)
+		lapSummary.If = utility.Round(intensity, .5, 2) * 100
+		lapSummary.Tss = int((float64(len(powerSeries)) * float64(lapSummary.Np) * intensity) / (float64(user.Ftp) * 3600) * 100)
+	}
+	lapSummary.WorkDone = int(float64(lapSummary.Avpower)*float64(len(powerSeries))) / 1000 //KJ
+	if user.Weight > 0 {
+		lapSummary.Wkg = utility.Round(float64(lapSummary.Avpower)/float64(user.Weight), .5, 2)
+	}
+	lapSummary = aerobicMetrics(lapSummary, powerSeries, heartSeries)
+	lapSummary.TimeInZone = zones.TimeInZone(powerSeries, heartSeries, user, user.Ftp, user.Thr)
+	return
+}
+
+// variability index (NP/avg power), efficiency factor (NP/avg HR) and Pw:HR aerobic decoupling between the first and second halves
+func aerobicMetrics(summary types.Metrics, powerSeries, heartSeries []int) types.Metrics {
+	if summary.Avpower > 0 && summary.Np > 0 {
+		summary.Vi = utility.Round(float64(summary.Np)/float64(summary.Avpower), .5, 2)
+	}
+	if summary.Avheart > 0 && summary.Np > 0 {
+		summary.Ef = utility.Round(float64(summary.Np)/float64(summary.Avheart), .5, 2)
+	}
+
+	//decoupling needs both power and heart rate for the whole of the series
+	if len(powerSeries) != len(heartSeries) || len(powerSeries) < 2 || summary.Avpower == 0 || summary.Avheart == 0 {
+		return summary
+	}
+	half := len(powerSeries) / 2
+	var firstPower, firstHr, secondPower, secondHr int
+	for i := 0; i < len(powerSeries); i++ {
+		if i < half {
+			firstPower += powerSeries[i]
+			firstHr += heartSeries[i]
+		} else {
+			secondPower += powerSeries[i]
+			secondHr += heartSeries[i]
+		}
+	}
+	if firstHr == 0 || secondHr == 0 || firstPower == 0 {
+		return summary
+	}
+	//the sample counts cancel out of each half's ratio
+	firstRatio := float64(firstPower) / float64(firstHr)
+	secondRatio := float64(secondPower) / float64(secondHr)
+	summary.Decoupling = utility.Round((firstRatio-secondRatio)/firstRatio*100, .5, 2)
+	return summary
+}
+
+// compare a selection of laps (usually the work intervals) against the first of them to show how efforts faded
+func compareLaps(lapSummaries []types.Metrics, endSummary types.Metrics, selected []int) []LapComparison {
+	comparisons := make([]LapComparison, 0)
+
+	//no selection, so pick out the efforts: laps of at least 30 seconds with above average power (or heart rate if no power)
+	if len(selected) == 0 {
+		for i, lapSummary := range lapSummaries {
+			if lapSummary.Dur < 30*time.Second {
+				continue
+			}
+			if (endSummary.Avpower > 0 && lapSummary.Avpower > endSummary.Avpower) || (endSummary.Avpower == 0 && lapSummary.Avheart > endSummary.Avheart) {
+				selected = append(selected, i+1)
+			}
+		}
+	}
+	//drop any laps the ride doesn't have
+	laps := make([]int, 0)
+	for _, lapNumber := range selected {
+		if lapNumber >= 1 && lapNumber <= len(lapSummaries) {
+			laps = append(laps, lapNumber)
+		}
+	}
+	if len(laps) < 2 {
+		//nothing to compare
+		return comparisons
+	}
+
+	first := lapSummaries[laps[0]-1]
+	for _, lapNumber := range laps {
+		lapSummary := lapSummaries[lapNumber-1]
+		var comparison LapComparison
+		comparison.Lap = lapNumber
+		comparison.Rep = len(comparisons) + 1
+		comparison.Metrics = lapSummary
+		if first.Avpower > 0 {
+			comparison.PowerFade = utility.Round(float64(lapSummary.Avpower-first.Avpower)/float64(first.Avpower)*100, .5, 1)
+		}
+		if first.Np > 0 {
+			comparison.NpFade = utility.Round(float64(lapSummary.Np-first.Np)/float64(first.Np)*100, .5, 1)
+		}
+		if first.Avheart > 0 {
+			comparison.HeartDrift = utility.Round(float64(lapSummary.Avheart-first.Avheart)/float64(first.Avheart)*100, .5, 1)
+		}
+		if lapSummary.Avheart > 0 {
+			comparison.PowerHr = utility.Round(float64(lapSummary.Avpower)/float64(lapSummary.Avheart), .5, 2)
+		}
+		comparisons = append(comparisons, comparison)
+	}
+	return comparisons
+}
+
+func viewActivity(activityId string, user types.UserSettings, meta ActivityMeta, selectedLaps []int) (p Page) {
 	//get any messages...
 	db, err := sql.Open("mysql", config.MySQLUser+":"+config.MySQLPass+"@tcp("+config.MySQLHost+":3306)/"+config.MySQLDB)
 
@@ -896,7 +1375,6 @@ func viewActivity(activityId string, user types.UserSettings, meta ActivityMeta)
 
 	var endSummary types.Metrics
 	var cpms types.CPMs
-	var zoneData types.Zones
 
 	//slices to hold mulitple rows
 	rows := make([]SampleRow, 0)
@@ -905,6 +1383,8 @@ func viewActivity(activityId string, user types.UserSettings, meta ActivityMeta)
 
 	//cp data
 	cpRows := make([]CpRow, 0)
+	//data quality corrections
+	corrections := make([]dataquality.Correction, 0)
 
 	hasPower := true
 	hasHeart := true
@@ -913,7 +1393,7 @@ func viewActivity(activityId string, user types.UserSettings, meta ActivityMeta)
 	powerSeries := make([]int, 0) //power time series data
 	heartSeries := make([]int, 0) //heart rate time series data
 
-	title, row_json, power_json, heart_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, has_power, has_heart, has_cadence, cur_ftp, cur_thr := getPreProcessed(activityId)
+	title, row_json, power_json, heart_json, cp_row_json, cp_data_json, lap_summaries_json, end_summary_json, quality_json, has_power, has_heart, has_cadence, cur_ftp, cur_thr, quality_score := getPreProcessed(activityId)
 
 	json.Unmarshal(row_json, &rows)
 	json.Unmarshal(power_json, &powerSeries) //need this?
@@ -922,64 +1402,74 @@ func viewActivity(activityId string, user types.UserSettings, meta ActivityMeta)
 	json.Unmarshal(cp_data_json, &cpms)
 	json.Unmarshal(lap_summaries_json, &lapSummaries)
 	json.Unmarshal(end_summary_json, &endSummary)
+	json.Unmarshal(quality_json, &corrections)
 	hasPower = has_power
 	hasHeart = has_heart
 	hasCadence = has_cadence
 	curThr := cur_thr
 
-	//count samples for calculations
-	samples := len(powerSeries)
-
-	//calulate power zones for this activity
-	if hasPower {
-		var sum int
-		var average float64
-		for i := user.SampleSize; i < samples; i++ {
-			//reset total
-			sum = 0
-			//get thirty second rolling slice
-			rollingPowerSlice := powerSeries[i-user.SampleSize : i]
-			for _, val := range rollingPowerSlice {
-				//sum the sliding slice values
-				sum += val
-			}
-			average = float64(sum / user.SampleSize)
-
-			if average < 0.55*float64(cur_ftp) {
-				zoneData.Z1++
-			} else if average > 0.55*float64(cur_ftp) && average <= 0.74*float64(cur_ftp) {
-				zoneData.Z2++
-			} else if average > 0.74*float64(cur_ftp) && average <= 0.89*float64(cur_ftp) {
-				zoneData.Z3++
-			} else if average > 0.89*float64(cur_ftp) && average <= 1.04*float64(cur_ftp) {
-				zoneData.Z4++
-			} else if average > 1.04*float64(cur_ftp) && average <= 1.2*float64(cur_ftp) {
-				zoneData.Z5++
-			} else if average > 1.2*float64(cur_ftp) {
-				zoneData.Z6++
-			}
-		}
+	//time in zone is stored when processed, only split the series again if the user's zone settings have since changed
+	zoneData := zones.ForActivity(endSummary, user, func() ([]int, []int, int, int) {
+		return powerSeries, heartSeries, cur_ftp, curThr
+	})
+	powerZones := zones.Power(user, cur_ftp)
+	heartZones := zones.Heart(user, curThr)
+	zoneLabels := types.ZoneLabels{
+		PowerModel: zones.Models[powerZones.Model].Label,
+		HeartModel: zones.Models[heartZones.Model].Label,
+		Power:      powerZones.Zones,
+		Heart:      heartZones.Zones,
 	}
 
-	if hasHeart {
-		for _, val := range heartSeries {
-			if float64(val) < 0.81*float64(curThr) {
-				zoneData.HR1++
-			} else if float64(val) > 0.81*float64(curThr) && float64(val) <= 0.89*float64(curThr) {
-				zoneData.HR2++
-			} else if float64(val) > 0.89*float64(curThr) && float64(val) <= 0.93*float64(curThr) {
-				zoneData.HR3++
-			} else if float64(val) > 0.93*float64(curThr) && float64(val) <= 0.99*float64(curThr) {
-				zoneData.HR4++
-			} else if float64(val) > 0.99*float64(curThr) && float64(val) <= 1.02*float64(curThr) {
-				zoneData.HR5a++
-			} else if float64(val) > 1.02*float64(curThr) && float64(val) <= 1.06*float64(curThr) {
-				zoneData.HR5b++
-			} else if float64(val) > 1.06*float64(curThr) {
-				zoneData.HR5c++
-			}
+	//distributions are stored when processed too, rows are only needed if the user's bin widths can't be regrouped from the stored ones
+	cadenceSeries := make([]int, len(rows))
+	for i, row := range rows {
+		cadenceSeries[i] = row.Cadence
+	}
+	histograms := histogram.ForActivity(endSummary, user, func() ([]int, []int, []int) {
+		return powerSeries, heartSeries, cadenceSeries
+	})
+
+	//pedalling quadrants as above, the scatter chart always needs the series
+	quadrants := quadrant.Summarise(quadrant.ForActivity(endSummary, user, func() ([]int, []int, int) {
+		return powerSeries, cadenceSeries, cur_ftp
+	}))
+	quadrants.Points = quadrant.Points(powerSeries, cadenceSeries, quadrants.CrankLength)
+
+	//cadence chosen in each power zone, again only regrouped if the user's power zones have changed
+	cadenceProfile := torque.ForActivity(endSummary, user, func() ([]int, []int, int) {
+		return powerSeries, cadenceSeries, cur_ftp
+	})
+
+	//climbs and the elevation profile
+	climbs := make([]climb.Climb, 0)
+	altitudeSeries := make([]float64, 0)
+	altitude_json, _, climb_json, estimated_json := getRoute(activityId)
+	json.Unmarshal(altitude_json, &altitudeSeries)
+	json.Unmarshal(climb_json, &climbs)
+
+	//power estimated from speed, only shown separately when it hasn't been used as the ride's power
+	estimatedSeries := make([]int, 0)
+	if !endSummary.EstimatedPower {
+		json.Unmarshal(estimated_json, &estimatedSeries)
+	}
+	estimatedAverage := 0
+	if len(estimatedSeries) > 0 {
+		estimatedAverage, _, _ = averages(estimatedSeries, estimatedSeries, estimatedSeries)
+	}
+	hasAltitude := false
+	for _, val := range altitudeSeries {
+		if val != 0 {
+			hasAltitude = true
+			break
 		}
 	}
+	if !hasAltitude {
+		altitudeSeries = altitudeSeries[:0]
+	}
+
+	//how the selected laps (intervals) compare with each other
+	lapCompare := compareLaps(lapSummaries, endSummary, selectedLaps)
 
 	var body = []byte("Activity overview")
 
@@ -990,22 +1480,42 @@ func viewActivity(activityId string, user types.UserSettings, meta ActivityMeta)
 	}
 
 	p = Page{
-		Title:        title,
-		ActivityMeta: meta,
-		Body:         body,
-		LapSummaries: lapSummaries,
-		EndSummary:   endSummary,
-		CPM:          cpms,
-		CPData:       cpRowsRev,
-		Data:         rows,
-		HasPower:     hasPower,
-		HasHeart:     hasHeart,
-		HasCadence:   hasCadence,
-		ZoneData:     zoneData,
-		CurThr:       curThr,
-		Theme:        user.Theme,
-		Demo:         user.Demo,
-		Message:      message,
+		Title:          title,
+		ActivityMeta:   meta,
+		Body:           body,
+		LapSummaries:   lapSummaries,
+		EndSummary:     endSummary,
+		CPM:            cpms,
+		CPData:         cpRowsRev,
+		LapCompare:     lapCompare,
+		LoadLabels:     loadmetric.Labels,
+		Corrections:    corrections,
+		QualityScore:   quality_score,
+		QualityLabels:  dataquality.Labels,
+		LoadMetric:     user.LoadMetric,
+		LoadMissing:    meta.TssOverride == 0 && loadmetric.Missing(endSummary, user),
+		RpeLoad:        loadmetric.SessionRpeLoad(meta.SessionRpe, endSummary.Dur),
+		Data:           rows,
+		HasPower:       hasPower,
+		HasHeart:       hasHeart,
+		HasCadence:     hasCadence,
+		ZoneData:       zoneData,
+		ZoneLabels:     zoneLabels,
+		Histograms:     histograms,
+		Quadrants:      quadrants,
+		QuadrantLabels: quadrant.Labels,
+		TorqueSeries:   torque.Series(powerSeries, cadenceSeries),
+		CadenceProfile: cadenceProfile,
+		Climbs:         climbs,
+		Altitude:       altitudeSeries,
+		Estimated:      estimatedSeries,
+		EstimatedAv:    estimatedAverage,
+		Records:        records.Set(user, endSummary.StartTime, endSummary.StartTime),
+		ScopeLabels:    records.ScopeLabels,
+		CurThr:         curThr,
+		Theme:          user.Theme,
+		Demo:           user.Demo,
+		Message:        message,
 	}
 	return
 }
@@ -0,0 +1,223 @@
+/* Aero field testing with Robert Chung's virtual elevation method. The rider's power, speed and mass give the elevation profile the ride "should" have had for a given CdA and Crr - on a loop (or against recorded altitude) the values that best match the real profile are the rider's CdA and Crr */
+package aero
+
+import (
+	"github.com/jezard/joulepersecond-go/types"
+	"github.com/jezard/joulepersecond-go/utility"
+	"math"
+)
+
+//physical constants and defaults
+const (
+	Gravity           = 9.80665
+	DefaultRho        = 1.225 //air density kg/m^3 (sea level, 15C)
+	DefaultCrr        = 0.004 //used when the fitted Crr isn't physical
+	DefaultBikeWeight = 8.0   //kg
+	DefaultCdA        = 0.32  //m^2, hoods
+	DefaultRideCrr    = 0.005 //rolling resistance assumed for power estimates (typical road tyres)
+	Efficiency        = 0.977 //drivetrain efficiency
+	gradientDistance  = 20.0  //metres the gradient is measured over for power estimates
+	speedSmoothing    = 5     //seconds speed is averaged over for power estimates
+)
+
+//a virtual elevation analysis
+type Result struct {
+	ActivityId        string
+	Start, End        int     //sample range analysed
+	Mass, Rho         float64 //kg (rider and bike), kg/m^3
+	CdA, Crr          float64
+	Fitted            bool    //whether CdA and Crr were solved for (rather than given)
+	Rmse              float64 //root mean square difference between the virtual and recorded elevation (metres)
+	Virtual, Recorded []float64
+}
+
+//the user's bike weight (kg)
+func BikeWeight(user types.UserSettings) float64 {
+	if user.BikeWeight <= 0 {
+		return DefaultBikeWeight
+	}
+	return user.BikeWeight
+}
+
+//the user's CdA
+func CdA(user types.UserSettings) float64 {
+	if user.CdA <= 0 {
+		return DefaultCdA
+	}
+	return user.CdA
+}
+
+//the user's Crr
+func Crr(user types.UserSettings) float64 {
+	if user.Crr <= 0 {
+		return DefaultRideCrr
+	}
+	return user.Crr
+}
+
+//estimated power each second from speed and gradient - empty when there's no speed data
+func Estimate(speed, altitude []float64, mass, cda, crr float64) []int {
+	estimate := make([]int, 0)
+	moving := false
+	for _, v := range speed {
+		if v > 0 {
+			moving = true
+			break
+		}
+	}
+	if !moving || len(altitude) < len(speed) {
+		return estimate
+	}
+	smoothed := make([]float64, len(speed))
+	var sum float64
+	for i, v := range speed {
+		sum += v
+		if i >= speedSmoothing {
+			sum -= speed[i-speedSmoothing]
+		}
+		count := math.Min(float64(i+1), speedSmoothing)
+		smoothed[i] = sum / count
+	}
+	//gradient over the last stretch of road (distance covered is the sum of the speeds)
+	back, covered := 0, 0.0
+	for i, v := range smoothed {
+		if i > 0 {
+			covered += speed[i]
+		}
+		for back < i-1 && covered-speed[back+1] >= gradientDistance {
+			covered -= speed[back+1]
+			back++
+		}
+		var slope, accel float64
+		if covered > 0 {
+			slope = (altitude[i] - altitude[back]) / covered
+		}
+		if i > 0 {
+			accel = v - smoothed[i-1]
+		}
+		//power against gravity, rolling resistance, air and change of speed
+		power := (mass*Gravity*v*(slope+crr) + 0.5*DefaultRho*cda*v*v*v + mass*accel*v) / Efficiency
+		if power < 0 || v == 0 {
+			power = 0
+		}
+		estimate = append(estimate, int(power))
+	}
+	return estimate
+}
+
+//speed each second (m/s) from the cumulative distance
+func Speed(distance []float64) []float64 {
+	speed := make([]float64, len(distance))
+	for i := 1; i < len(distance); i++ {
+		if distance[i] >= distance[i-1] {
+			speed[i] = distance[i] - distance[i-1]
+		}
+	}
+	if len(speed) > 1 {
+		speed[0] = speed[1]
+	}
+	return speed
+}
+
+//the virtual elevation profile, starting from the first recorded altitude
+func Virtual(powerSeries []int, speed []float64, start float64, mass, rho, cda, crr float64) []float64 {
+	a, b, c := terms(powerSeries, speed, mass, rho)
+	virtual := make([]float64, len(a))
+	for i := range a {
+		virtual[i] = utility.Round(start+a[i]-crr*b[i]-cda*c[i], .5, 2)
+	}
+	return virtual
+}
+
+//the CdA and Crr that best match the recorded altitude (least squares) - Crr is fixed at the default where the fit isn't physical
+func Solve(powerSeries []int, speed, altitude []float64, mass, rho float64) (cda, crr float64) {
+	a, b, c := terms(powerSeries, speed, mass, rho)
+	if len(a) == 0 || len(altitude) < len(a) {
+		return 0, DefaultCrr
+	}
+	//recorded - start - a = -crr*b - cda*c
+	var bb, bc, cc, by, cy float64
+	for i := range a {
+		y := a[i] - (altitude[i] - altitude[0])
+		bb += b[i] * b[i]
+		bc += b[i] * c[i]
+		cc += c[i] * c[i]
+		by += b[i] * y
+		cy += c[i] * y
+	}
+	det := bb*cc - bc*bc
+	if det != 0 {
+		crr = (by*cc - cy*bc) / det
+		cda = (cy*bb - by*bc) / det
+	}
+	if det == 0 || crr <= 0 || cda <= 0 {
+		crr = DefaultCrr
+		if cc != 0 {
+			cda = (cy - crr*bc) / cc
+		}
+	}
+	return utility.Round(cda, .5, 4), utility.Round(crr, .5, 5)
+}
+
+//root mean square difference between two profiles
+func Rmse(virtual, recorded []float64) float64 {
+	if len(virtual) == 0 || len(recorded) < len(virtual) {
+		return 0
+	}
+	var sum float64
+	for i := range virtual {
+		sum += (virtual[i] - recorded[i]) * (virtual[i] - recorded[i])
+	}
+	return utility.Round(math.Sqrt(sum/float64(len(virtual))), .5, 2)
+}
+
+//run the analysis over a range of samples - CdA and Crr are solved for unless both are given
+func Analyse(powerSeries []int, speed, altitude []float64, start, end int, mass, rho, cda, crr float64) (result Result) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(powerSeries) || end > len(speed) || end > len(altitude) {
+		end = int(math.Min(float64(len(powerSeries)), math.Min(float64(len(speed)), float64(len(altitude)))))
+	}
+	if rho <= 0 {
+		rho = DefaultRho
+	}
+	result.Start, result.End, result.Mass, result.Rho = start, end, mass, rho
+	if end-start < 2 || mass <= 0 {
+		return
+	}
+	powerSeries, speed, altitude = powerSeries[start:end], speed[start:end], altitude[start:end]
+	if cda <= 0 || crr <= 0 {
+		cda, crr = Solve(powerSeries, speed, altitude, mass, rho)
+		result.Fitted = true
+	}
+	result.CdA, result.Crr = cda, crr
+	result.Virtual = Virtual(powerSeries, speed, altitude[0], mass, rho, cda, crr)
+	result.Recorded = altitude
+	result.Rmse = Rmse(result.Virtual, result.Recorded)
+	return
+}
+
+//the cumulative elevation terms - virtual elevation = start + a - crr*b - cda*c
+func terms(powerSeries []int, speed []float64, mass, rho float64) (a, b, c []float64) {
+	samples := len(powerSeries)
+	if len(speed) < samples {
+		samples = len(speed)
+	}
+	a = make([]float64, samples)
+	b = make([]float64, samples)
+	c = make([]float64, samples)
+	for i := 1; i < samples; i++ {
+		a[i], b[i], c[i] = a[i-1], b[i-1], c[i-1]
+		v := (speed[i] + speed[i-1]) / 2
+		if v < 1 { //stopped, or too slow for the model
+			continue
+		}
+		accel := speed[i] - speed[i-1]
+		//slope = P/(m g v) - crr - cda rho v^2/(2 m g) - accel/g, distance covered is v
+		a[i] += float64(powerSeries[i])*Efficiency/(mass*Gravity) - accel*v/Gravity
+		b[i] += v
+		c[i] += rho * v * v * v / (2 * mass * Gravity)
+	}
+	return
+}
@@ -6,10 +6,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gocql/gocql"
+	"github.com/jezard/joulepersecond-go/banister"
+	"github.com/jezard/joulepersecond-go/climb"
 	"github.com/jezard/joulepersecond-go/conf"
+	"github.com/jezard/joulepersecond-go/dailyload"
+	"github.com/jezard/joulepersecond-go/durability"
+	"github.com/jezard/joulepersecond-go/histogram"
+	"github.com/jezard/joulepersecond-go/loadmetric"
+	"github.com/jezard/joulepersecond-go/periods"
+	"github.com/jezard/joulepersecond-go/plan"
+	"github.com/jezard/joulepersecond-go/profile"
+	"github.com/jezard/joulepersecond-go/quadrant"
+	"github.com/jezard/joulepersecond-go/torque"
 	"github.com/jezard/joulepersecond-go/types"
 	"github.com/jezard/joulepersecond-go/usersettings"
 	"github.com/jezard/joulepersecond-go/utility"
+	"github.com/jezard/joulepersecond-go/weight"
+	"github.com/jezard/joulepersecond-go/zones"
 	"html/template"
 	"io/ioutil"
 	"log"
@@ -27,6 +40,8 @@ type CpRow struct {
 	CpVal  int
 	CpAhr  int
 	CpAcad int
+	HrVal  int //mean maximal heart rate for the duration
+	CadVal int //mean maximal cadence for the duration
 }
 
 type Cp3 struct {
@@ -42,6 +57,46 @@ type Cp3Legend struct {
 	Series1, Series2, Series3 string
 }
 
+//a named period for the power curve comparison
+type DateRange struct {
+	Name     string
+	From, To time.Time //inclusive days
+}
+
+//one line of the power curve comparison
+type CurveSeries struct {
+	Name   string
+	Watts  []int    //one for each of the comparison's durations, 0 where the series has no value
+	Labels []string //the ride each value came from
+}
+
+//power curves for any number of periods (and a single activity) on the same durations
+type CurveComparison struct {
+	Durations []int //in seconds
+	Series    []CurveSeries
+}
+
+//modelled (Banister) against measured performance for a day
+type PerformancePoint struct {
+	Year, Month, Day int     //for Highcharts (js months start at naught)
+	Predicted        float64 //watts
+	Measured         float64 //notable CP, 0 on days without one
+	Forecast         bool    //predicted from planned load
+}
+
+//mean maximal heart rate and cadence for a duration - the best from each of the three periods
+type Mm3 struct {
+	CpTime           int //in seconds
+	Hr1, Hr2, Hr3    int
+	Cad1, Cad2, Cad3 int
+}
+
+//cadence and torque in each power zone for a week
+type CadenceWeek struct {
+	TimeLabel string
+	Bands     []types.PowerBand
+}
+
 type Hvp struct {
 	AvHeartRate      int
 	AvPower          int
@@ -50,6 +105,8 @@ type Hvp struct {
 	NotableCp        float64
 	CpHr             int //the average heart rate for the notable critical power value
 	CpCad            int
+	Ef               float64 //efficiency factor
+	Decoupling       float64 //Pw:HR aerobic decoupling (percent)
 	HasValue         bool
 	Meta             ActivityMeta
 }
@@ -67,20 +124,20 @@ type Tvd struct {
 }
 
 type Hpbz struct { //merged store of data for Heart/Power by zone chart
-	StartTime                                                                                                                           time.Time
-	Has_heart, Has_power                                                                                                                bool
-	Samples                                                                                                                             int //in seconds to calculate average
-	CountPZ1, CountPZ2, CountPZ3, CountPZ4, CountPZ5, CountPZ6, CountHZ1, CountHZ2, CountHZ3, CountHZ4, CountHZ5a, CountHZ5b, CountHZ5c int
+	StartTime              time.Time
+	Has_heart, Has_power   bool
+	Samples                int   //in seconds to calculate average
+	CountPower, CountHeart []int //seconds in each of the user's power and heart rate zones
 }
 
 type Pbz struct {
-	TimeLabel                          string
-	AvZ1, AvZ2, AvZ3, AvZ4, AvZ5, AvZ6 float64
+	TimeLabel string
+	Zones     []float64 //hours in each power zone
 }
 
 type Hbz struct {
-	TimeLabel                                   string
-	AvZ1, AvZ2, AvZ3, AvZ4, AvZ5a, AvZ5b, AvZ5c float64
+	TimeLabel string
+	Zones     []float64 //hours in each heart rate zone
 }
 
 //store various types of information about an activity - 1 record per activity
@@ -95,11 +152,18 @@ type Page struct {
 	Title                           string
 	EndSummary                      types.Metrics
 	FfData                          []types.Ff_data_point
+	EventForecast                   types.Ff_data_point //projected fitness and freshness on the event date
+	Banister                        banister.Model
+	BanisterCurve                   []PerformancePoint
+	BanisterTaper                   banister.Taper
 	CpData                          []Cp3
+	CpCompare                       CurveComparison
 	HvpData                         []Hvp
 	TvdData                         []Tvd
 	DashboardTvd                    Tvd
 	CpLegend1, CpLegend2, CpLegend3 string
+	MmData                          []Mm3     //mean maximal heart rate and cadence
+	MmLegend                        Cp3Legend //periods of the mean maximal heart rate and cadence series
 	TvdLegend                       string
 	HvpLabel                        string
 	PbzData                         []Pbz
@@ -107,18 +171,46 @@ type Page struct {
 	Settings                        types.UserSettings
 	Filter                          Filter
 	ZoneLabels                      types.ZoneLabels
+	Histograms                      types.Histograms
+	Quadrants                       quadrant.Summary
+	QuadrantLabels                  [4]string
+	CadenceData                     []CadenceWeek
+	Climbs                          []climb.Climb
+	Durability                      []types.DurabilityCurve
+	DurabilityRows                  []durability.Row
+	Profile                         profile.Profile //power profile for the whole period
+	WeightLog                       []weight.Entry
+	ProfileTrend                    []profile.Profile //month by month
 	StandardRidesHTML               template.HTML
 }
 type Filter struct { //need to refactor some of the filters in Page struct into here...
 	Race, Train, Indoor, Outdoor, HeartData                              bool
 	Historylen                                                           int  //filter value
 	ShowTss, ShowMmp, ShowDur, ShowPbz, ShowHbz, ShowHvp, HasGraphOutput bool //whether or not to process and show graphs
+	ShowMmHr                                                             bool //mean maximal heart rate and cadence
+	ShowHist                                                             bool //power, heart rate and cadence distributions
+	ShowQuad                                                             bool //pedalling quadrants
+	ShowCad                                                              bool //cadence and torque by power zone
+	ShowClimbs                                                           bool //the user's climbs
+	ShowDurability                                                       bool //power curves after N kJ of work
+	ShowCompare                                                          bool //power curves over named date ranges
+	ShowProfile                                                          bool //power profile (W/kg) and rider type
+	ShowWeight                                                           bool //weight and body fat trend
+	Ranges                                                               []DateRange
+	Overlay                                                              string    //activity id to draw against the date ranges
+	EventDate                                                            time.Time //fitness and freshness are projected to this date
+	PlanTss                                                              int       //planned daily TSS for days without a plan entry
+	ShowBanister                                                         bool      //fitness-fatigue model fitted to notable CPs
+	ShowRamp, ShowMonotony, ShowAcwr                                     bool      //overload overlays on the fitness/freshness chart (monotony along with strain)
+	RampWarning, MonotonyWarning, StrainWarning, AcwrWarning             float64   //overload warning thresholds
 	S5, S20, S60, S300, S1200, S3600                                     bool
 	CpFilter                                                             int  //5,20,60 sec etc...
 	ShowCPs                                                              bool //whether user wishes to show notable CPs on graph
 	HvpTo, HvpFrom                                                       int  //time in minutes
 	OffsetDays                                                           int  //number of days to end filter period
 	StandardRides                                                        []int
+	MinQuality                                                           int    //exclude activities with a data quality score below this
+	Period                                                               string //training load and zone charts by day, week, month or year (empty to go by the history length)
 }
 
 //create a data type to represent aggregated sample data
@@ -197,10 +289,64 @@ func AnalysisHandler(w http.ResponseWriter, r *http.Request) {
 		if showTss == "checked" {
 			filter.ShowTss = true
 		}
+		showBanister := r.FormValue("show-banister")
+		if showBanister == "checked" {
+			filter.ShowBanister = true
+		}
+		if r.FormValue("show-ramp") == "checked" {
+			filter.ShowRamp = true
+		}
+		if r.FormValue("show-monotony") == "checked" {
+			filter.ShowMonotony = true
+		}
+		if r.FormValue("show-acwr") == "checked" {
+			filter.ShowAcwr = true
+		}
+		filter.RampWarning = warning(r.FormValue("ramp-warning"), dailyload.RampWarning)
+		filter.MonotonyWarning = warning(r.FormValue("monotony-warning"), dailyload.MonotonyWarning)
+		filter.StrainWarning = warning(r.FormValue("strain-warning"), dailyload.StrainWarning)
+		filter.AcwrWarning = warning(r.FormValue("acwr-warning"), dailyload.AcwrWarning)
 		showMmp := r.FormValue("show-mmp")
 		if showMmp == "checked" {
 			filter.ShowMmp = true
 		}
+		showMmHr := r.FormValue("show-mmhr")
+		if showMmHr == "checked" {
+			filter.ShowMmHr = true
+		}
+		showHist := r.FormValue("show-hist")
+		if showHist == "checked" {
+			filter.ShowHist = true
+		}
+		showQuad := r.FormValue("show-quad")
+		if showQuad == "checked" {
+			filter.ShowQuad = true
+		}
+		showCad := r.FormValue("show-cad")
+		if showCad == "checked" {
+			filter.ShowCad = true
+		}
+		showClimbs := r.FormValue("show-climbs")
+		if showClimbs == "checked" {
+			filter.ShowClimbs = true
+		}
+		showWeight := r.FormValue("show-weight")
+		if showWeight == "checked" {
+			filter.ShowWeight = true
+		}
+		showProfile := r.FormValue("show-profile")
+		if showProfile == "checked" {
+			filter.ShowProfile = true
+		}
+		showCompare := r.FormValue("show-compare")
+		if showCompare == "checked" {
+			filter.ShowCompare = true
+		}
+		filter.Overlay = r.FormValue("overlay-activity")
+		showDurability := r.FormValue("show-durability")
+		if showDurability == "checked" {
+			filter.ShowDurability = true
+		}
 		showDur := r.FormValue("show-dur")
 		if showDur == "checked" {
 			filter.ShowDur = true
@@ -237,6 +383,20 @@ func AnalysisHandler(w http.ResponseWriter, r *http.Request) {
 			filter.OffsetDays = offsetDays
 		}
 
+		eventDate, err := time.ParseInLocation(plan.DateFormat, r.FormValue("event-date"), user.Location) //a day in the user's time zone, as the daily load days are
+		if err == nil {
+			filter.EventDate = eventDate
+		}
+		planTss, err := strconv.Atoi(r.FormValue("plan-tss"))
+		if err == nil && planTss > 0 {
+			filter.PlanTss = planTss
+		}
+
+		minQuality, err := strconv.Atoi(r.FormValue("min-quality"))
+		if err == nil {
+			filter.MinQuality = minQuality
+		}
+
 		//more filters
 		if r.Method == "POST" {
 			indoor := r.FormValue("is-indoor")
@@ -282,7 +442,7 @@ func AnalysisHandler(w http.ResponseWriter, r *http.Request) {
 			filter.HeartData = true
 		}
 
-		if !filter.ShowTss && !filter.ShowMmp && !filter.ShowDur && !filter.ShowPbz && !filter.ShowHbz && !filter.ShowHvp {
+		if !filter.ShowTss && !filter.ShowBanister && !filter.ShowMmp && !filter.ShowCompare && !filter.ShowProfile && !filter.ShowWeight && !filter.ShowMmHr && !filter.ShowHist && !filter.ShowQuad && !filter.ShowCad && !filter.ShowClimbs && !filter.ShowDurability && !filter.ShowDur && !filter.ShowPbz && !filter.ShowHbz && !filter.ShowHvp {
 			filter.HasGraphOutput = false
 		} else {
 			filter.HasGraphOutput = true
@@ -330,12 +490,37 @@ func AnalysisHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		//chart periods, left empty to default by the (allowed) history length
+		if period := r.FormValue("period"); periods.Unit(period, 0) == period {
+			filter.Period = period
+		}
+
 		sr := r.Form["standard_rides[]"]
 		for i := 0; i < len(sr); i++ {
 			standard_ride, _ := strconv.Atoi(sr[i])
 			filter.StandardRides = append(filter.StandardRides, standard_ride)
 		}
 
+		//named date ranges for the power curve comparison - rows without valid dates are ignored
+		rangeNames := r.Form["range_name[]"]
+		rangeFroms := r.Form["range_from[]"]
+		rangeTos := r.Form["range_to[]"]
+		for i := 0; i < len(rangeNames) && i < len(rangeFroms) && i < len(rangeTos); i++ {
+			from, err := time.Parse("2006-01-02", rangeFroms[i])
+			if err != nil {
+				continue
+			}
+			to, err := time.Parse("2006-01-02", rangeTos[i])
+			if err != nil || to.Before(from) {
+				continue
+			}
+			name := rangeNames[i]
+			if name == "" {
+				name = rangeFroms[i] + " to " + rangeTos[i]
+			}
+			filter.Ranges = append(filter.Ranges, DateRange{Name: name, From: from, To: to})
+		}
+
 		p := view(user, filter)
 		t, _ := template.ParseFiles(config.Tpath + "analysis.html")
 		t.Execute(w, p)
@@ -349,12 +534,6 @@ func hpbz(user types.UserSettings, filter Filter) ([]Hbz, []Pbz) {
 
 	var user_data types.Metrics
 	var end_summary_json []byte
-	var heart_json []byte
-	var power_json []byte
-	var cur_ftp int
-	var cur_thr int
-	var power_series []int
-	var heart_series []int
 	var has_power, has_heart bool
 	var activity_id string
 	var activity_start time.Time
@@ -370,265 +549,74 @@ func hpbz(user types.UserSettings, filter Filter) ([]Hbz, []Pbz) {
 	cluster.Consistency = gocql.Quorum
 	session, _ := cluster.CreateSession()
 	defer session.Close()
-	var sH1, sH2, sH3, sH4, sH5a, sH5b, sH5c, sP1, sP2, sP3, sP4, sP5, sP6 int
+	sP := zones.Power(user, user.Ftp).Counts() //zones for the period (sized from the user's current models)
+	sH := zones.Heart(user, user.Thr).Counts()
 
 	timeNow := time.Now()
 	timeThen := timeNow.AddDate(0, 0, -filter.Historylen)
 
 	//get all of the user's data (at least all for now) TODO limit these queries by date if poss.
-	iter := session.Query(`SELECT activity_start, activity_id FROM joulepersecond.user_activity WHERE user_id = ? AND activity_start > ? ORDER BY activity_start ASC`, user_id, timeThen).Iter()
+	iter := session.Query(`SELECT activity_start, activity_id, end_summary_json, has_power, has_heart FROM joulepersecond.user_activity WHERE user_id = ? AND activity_start > ? ORDER BY activity_start ASC`, user_id, timeThen).Iter()
 
-	for iter.Scan(&activity_start, &activity_id) {
+	for iter.Scan(&activity_start, &activity_id, &end_summary_json, &has_power, &has_heart) {
+		if !has_heart && !has_power {
+			continue
+		}
+		user_data = types.Metrics{}
+		json.Unmarshal(end_summary_json, &user_data)
 
-		iter := session.Query(`SELECT power_json, heart_json, end_summary_json, has_power, has_heart, cur_ftp, cur_thr FROM joulepersecond.proc_activity WHERE activity_id = ? `, activity_id).Iter()
-		for iter.Scan(&power_json, &heart_json, &end_summary_json, &has_power, &has_heart, &cur_ftp, &cur_thr) {
-			json.Unmarshal(end_summary_json, &user_data)
+		//time in zone is worked out when the activity is processed - the time series are only needed if the user has since changed their zones
+		timeInZone := zones.ForActivity(user_data, user, func() (power_series, heart_series []int, cur_ftp, cur_thr int) {
+			var power_json, heart_json []byte
+			session.Query(`SELECT power_json, heart_json, cur_ftp, cur_thr FROM joulepersecond.proc_activity WHERE activity_id = ? `, activity_id).Scan(&power_json, &heart_json, &cur_ftp, &cur_thr)
 			json.Unmarshal(power_json, &power_series)
 			json.Unmarshal(heart_json, &heart_series)
-
-			temp_row.StartTime = activity_start
-			//TODO next: Split all time series data in to zones and add it to temp_row/(s) for further date processing
-
-			if has_power {
-				temp_row.Samples = len(power_series)
-				temp_row.Has_power = true
-			}
-			if has_heart {
-				temp_row.Samples = len(heart_series)
-				temp_row.Has_heart = true
-			}
-			if !has_heart && !has_power {
-				break
-			}
-
-			//clear the values
-			temp_row.CountPZ1 = 0
-			temp_row.CountPZ2 = 0
-			temp_row.CountPZ3 = 0
-			temp_row.CountPZ4 = 0
-			temp_row.CountPZ5 = 0
-			temp_row.CountPZ6 = 0
-
-			temp_row.CountHZ1 = 0
-			temp_row.CountHZ2 = 0
-			temp_row.CountHZ3 = 0
-			temp_row.CountHZ4 = 0
-			temp_row.CountHZ5a = 0
-			temp_row.CountHZ5b = 0
-			temp_row.CountHZ5c = 0
-
-			if has_power {
-
-				var sum int
-				var average float64
-				for i := user.SampleSize; i < temp_row.Samples; i++ {
-					//reset total
-					sum = 0
-					//get thirty second rolling slice
-					rollingPowerSlice := power_series[i-user.SampleSize : i]
-					for _, val := range rollingPowerSlice {
-						//sum the sliding slice values
-						sum += val
-					}
-					average = float64(sum / user.SampleSize)
-
-					if average < 0.55*float64(cur_ftp) {
-						temp_row.CountPZ1++
-					} else if average > 0.55*float64(cur_ftp) && average <= 0.74*float64(cur_ftp) {
-						temp_row.CountPZ2++
-					} else if average > 0.74*float64(cur_ftp) && average <= 0.89*float64(cur_ftp) {
-						temp_row.CountPZ3++
-					} else if average > 0.89*float64(cur_ftp) && average <= 1.04*float64(cur_ftp) {
-						temp_row.CountPZ4++
-					} else if average > 1.04*float64(cur_ftp) && average <= 1.2*float64(cur_ftp) {
-						temp_row.CountPZ5++
-					} else if average > 1.2*float64(cur_ftp) {
-						temp_row.CountPZ6++
-					}
-				}
-			}
-
-			//loop through each sample and post the value into the correct pidgeon hole
-			for i := 0; i < temp_row.Samples; i++ {
-
-				if has_heart {
-					if float64(heart_series[i]) < 0.81*float64(cur_thr) {
-						temp_row.CountHZ1++
-					} else if float64(heart_series[i]) > 0.81*float64(cur_thr) && float64(heart_series[i]) <= 0.89*float64(cur_thr) {
-						temp_row.CountHZ2++
-					} else if float64(heart_series[i]) > 0.89*float64(cur_thr) && float64(heart_series[i]) <= 0.93*float64(cur_thr) {
-						temp_row.CountHZ3++
-					} else if float64(heart_series[i]) > 0.93*float64(cur_thr) && float64(heart_series[i]) <= 0.99*float64(cur_thr) {
-						temp_row.CountHZ4++
-					} else if float64(heart_series[i]) > 0.99*float64(cur_thr) && float64(heart_series[i]) <= 1.02*float64(cur_thr) {
-						temp_row.CountHZ5a++
-					} else if float64(heart_series[i]) > 1.02*float64(cur_thr) && float64(heart_series[i]) <= 1.06*float64(cur_thr) {
-						temp_row.CountHZ5b++
-					} else if float64(heart_series[i]) > 1.06*float64(cur_thr) {
-						temp_row.CountHZ5c++
-					}
-				}
-
-			}
-			temp_rows = append(temp_rows, temp_row)
-
-		}
-
-	}
-	clearVals := func() {
-		sH1 = 0
-		sH2 = 0
-		sH3 = 0
-		sH4 = 0
-		sH5a = 0
-		sH5b = 0
-		sH5c = 0
-		sP1 = 0
-		sP2 = 0
-		sP3 = 0
-		sP4 = 0
-		sP5 = 0
-		sP6 = 0
-	}
-	//so now for each activity we have the sum of each of the zones (value for each second * number seconds) and the number of seconds to divide by later once summed by date
-	//loope through each retrieved activity
-	var lastActivity time.Time
-	var numResult int
-
-	for i := 1; i < len(temp_rows); i++ {
-		if i == 1 {
-			lastActivity = temp_rows[i].StartTime
-		}
-
-		//if we want to show data in monthly format
-		if filter.Historylen > 366 { //show over 365 days as monthly
-			//set last activity on first iteration only
-			thisDate := temp_rows[i].StartTime
-			prevDate := lastActivity
-
-			//if we're still in the current month sum these values
-			if thisDate.Month() != prevDate.Month() || i == len(temp_rows)-1 {
-				var summedMonthlyHbz Hbz
-				var summedMonthlyPbz Pbz
-
-				summedMonthlyHbz.AvZ1 = utility.Round((float64(sH1) / 3600.0), .5, 2)
-				summedMonthlyHbz.AvZ2 = utility.Round((float64(sH2) / 3600.0), .5, 2)
-				summedMonthlyHbz.AvZ3 = utility.Round((float64(sH3) / 3600.0), .5, 2)
-				summedMonthlyHbz.AvZ4 = utility.Round((float64(sH4) / 3600.0), .5, 2)
-				summedMonthlyHbz.AvZ5a = utility.Round((float64(sH5a) / 3600.0), .5, 2)
-				summedMonthlyHbz.AvZ5b = utility.Round((float64(sH5b) / 3600.0), .5, 2)
-				summedMonthlyHbz.AvZ5c = utility.Round((float64(sH5c) / 3600.0), .5, 2)
-
-				summedMonthlyPbz.AvZ1 = utility.Round((float64(sP1) / 3600.0), .5, 2)
-				summedMonthlyPbz.AvZ2 = utility.Round((float64(sP2) / 3600.0), .5, 2)
-				summedMonthlyPbz.AvZ3 = utility.Round((float64(sP3) / 3600.0), .5, 2)
-				summedMonthlyPbz.AvZ4 = utility.Round((float64(sP4) / 3600.0), .5, 2)
-				summedMonthlyPbz.AvZ5 = utility.Round((float64(sP5) / 3600.0), .5, 2)
-				summedMonthlyPbz.AvZ6 = utility.Round((float64(sP6) / 3600.0), .5, 2)
-
-				var month time.Month
-				var year string
-
-				if thisDate.Month() != prevDate.Month() {
-					month = prevDate.Month()
-					year = strconv.Itoa(prevDate.Year())
-				} else {
-					month = thisDate.Month()
-					year = strconv.Itoa(thisDate.Year())
-				}
-
-				monthStr := month.String()
-				summedMonthlyHbz.TimeLabel = monthStr[0:3] + " '" + year[2:4]
-				summedMonthlyPbz.TimeLabel = monthStr[0:3] + " '" + year[2:4]
-				clearVals()
-
-				hbz_data = append(hbz_data, summedMonthlyHbz)
-				pbz_data = append(pbz_data, summedMonthlyPbz)
-
-				//reset the last activity date for the next loop
-				lastActivity = thisDate
+			return
+		})
+
+		temp_row.StartTime = activity_start
+		temp_row.Samples = int(user_data.Dur.Seconds())
+		temp_row.Has_power = has_power
+		temp_row.Has_heart = has_heart
+		temp_row.CountPower = timeInZone.Power
+		temp_row.CountHeart = timeInZone.Heart
+		temp_rows = append(temp_rows, temp_row)
+	}
+	//hours in each zone
+	hours := func(seconds []int) []float64 {
+		vals := make([]float64, len(seconds))
+		for z, val := range seconds {
+			vals[z] = utility.Round((float64(val) / 3600.0), .5, 2)
+		}
+		return vals
+	}
+	//sum each activity's time in zone by calendar period (see the periods package), every period in the range getting a bar
+	buckets := periods.Range(timeThen, timeNow, periods.Unit(filter.Period, filter.Historylen), user.WeekStart, user.Location, filter.Historylen < 120)
+	sumP := make([][]int, len(buckets))
+	sumH := make([][]int, len(buckets))
+	for i := range buckets {
+		sumP[i] = make([]int, len(sP))
+		sumH[i] = make([]int, len(sH))
+	}
+	for _, row := range temp_rows {
+		i := periods.Index(buckets, row.StartTime)
+		if i < 0 {
+			continue
+		}
+		for z, val := range row.CountPower {
+			if z < len(sP) {
+				sumP[i][z] += val
 			}
-
-		} else {
-			thisDate := temp_rows[i].StartTime
-			prevDate := lastActivity
-			prevIterDate := temp_rows[i-1].StartTime
-
-			_, thisDateWeek := thisDate.ISOWeek() //adding day keeps i in the correct week
-			_, prevDateWeek := prevDate.ISOWeek() // "
-
-			if thisDateWeek != prevDateWeek || i == len(temp_rows)-1 { //if new week or last activity
-				var summedWeeklyHbz Hbz
-				var summedWeeklyPbz Pbz
-				numResult++
-
-				summedWeeklyHbz.AvZ1 = utility.Round((float64(sH1) / 3600.0), .5, 2)
-				summedWeeklyHbz.AvZ2 = utility.Round((float64(sH2) / 3600.0), .5, 2)
-				summedWeeklyHbz.AvZ3 = utility.Round((float64(sH3) / 3600.0), .5, 2)
-				summedWeeklyHbz.AvZ4 = utility.Round((float64(sH4) / 3600.0), .5, 2)
-				summedWeeklyHbz.AvZ5a = utility.Round((float64(sH5a) / 3600.0), .5, 2)
-				summedWeeklyHbz.AvZ5b = utility.Round((float64(sH5b) / 3600.0), .5, 2)
-				summedWeeklyHbz.AvZ5c = utility.Round((float64(sH5c) / 3600.0), .5, 2)
-
-				summedWeeklyPbz.AvZ1 = utility.Round((float64(sP1) / 3600.0), .5, 2)
-				summedWeeklyPbz.AvZ2 = utility.Round((float64(sP2) / 3600.0), .5, 2)
-				summedWeeklyPbz.AvZ3 = utility.Round((float64(sP3) / 3600.0), .5, 2)
-				summedWeeklyPbz.AvZ4 = utility.Round((float64(sP4) / 3600.0), .5, 2)
-				summedWeeklyPbz.AvZ5 = utility.Round((float64(sP5) / 3600.0), .5, 2)
-				summedWeeklyPbz.AvZ6 = utility.Round((float64(sP6) / 3600.0), .5, 2)
-
-				monthS := prevDate.Month()
-				dayS := strconv.Itoa(prevDate.Day())
-				var dayF string
-
-				var monthF time.Month
-				if thisDateWeek != prevDateWeek {
-					monthF = prevIterDate.Month()
-					dayF = strconv.Itoa(prevIterDate.Day())
-				} else {
-					monthF = thisDate.Month()
-					dayF = strconv.Itoa(thisDate.Day())
-				}
-
-				monthStrS := monthS.String()
-				monthAbrS := monthStrS[0:3]
-				monthStrF := monthF.String()
-				monthAbrF := monthStrF[0:3]
-
-				//format labels according to number to displau
-				if filter.Historylen < 120 {
-					summedWeeklyHbz.TimeLabel = dayS + " " + monthAbrS + " - " + dayF + " " + monthAbrF
-					summedWeeklyPbz.TimeLabel = dayS + " " + monthAbrS + " - " + dayF + " " + monthAbrF
-				} else {
-					summedWeeklyHbz.TimeLabel = dayS + " " + monthAbrS
-					summedWeeklyPbz.TimeLabel = dayS + " " + monthAbrS
-				}
-
-				clearVals()
-
-				hbz_data = append(hbz_data, summedWeeklyHbz)
-				pbz_data = append(pbz_data, summedWeeklyPbz)
-
-				//reset the last activity date for the next loop
-				lastActivity = thisDate
-
+		}
+		for z, val := range row.CountHeart {
+			if z < len(sH) {
+				sumH[i][z] += val
 			}
-
 		}
-		sP1 += temp_rows[i].CountPZ1
-		sP2 += temp_rows[i].CountPZ2
-		sP3 += temp_rows[i].CountPZ3
-		sP4 += temp_rows[i].CountPZ4
-		sP5 += temp_rows[i].CountPZ5
-		sP6 += temp_rows[i].CountPZ6
-
-		sH1 += temp_rows[i].CountHZ1
-		sH2 += temp_rows[i].CountHZ2
-		sH3 += temp_rows[i].CountHZ3
-		sH4 += temp_rows[i].CountHZ4
-		sH5a += temp_rows[i].CountHZ5a
-		sH5b += temp_rows[i].CountHZ5b
-		sH5c += temp_rows[i].CountHZ5c
+	}
+	for i, bucket := range buckets {
+		hbz_data = append(hbz_data, Hbz{TimeLabel: bucket.Label, Zones: hours(sumH[i])})
+		pbz_data = append(pbz_data, Pbz{TimeLabel: bucket.Label, Zones: hours(sumP[i])})
 	}
 
 	return hbz_data, pbz_data
@@ -746,41 +734,10 @@ func hvp(user types.UserSettings, filter Filter) []Hvp {
 		}
 
 		//filter indoor/outdoor and competitive/non-competitive (f2 and f3)
-		f2 = true
-		f3 = true
+		f2, f3 = metaFilter(hvp_data_point.Meta, filter)
 		f4 = true
-		if hvp_data_point.Meta.ActivityID != "" { //only filter when there IS meta data - TODO add note to user on activity page that they need to add meta for the filter to work correctly - filters are only applied when values are supplied!!!!
-			if filter.Indoor || filter.Outdoor { //filter on
-				if filter.Indoor == true && filter.Outdoor == false {
-					if hvp_data_point.Meta.OutdoorRide == true {
-						f2 = false
-					}
-				}
-				if filter.Indoor == false && filter.Outdoor == true {
-					if hvp_data_point.Meta.IndoorRide == true {
-						f2 = false
-					}
-				}
-			} else { //no user filter
-				f2 = false
-			}
-			if filter.Race || filter.Train { //filter on
-				if filter.Race == true && filter.Train == false {
-					if hvp_data_point.Meta.Train == true {
-						f3 = false
-					}
-				}
-				if filter.Race == false && filter.Train == true {
-					if hvp_data_point.Meta.Race == true {
-						f3 = false
-					}
-				}
-			} else { //no user filter
-				f3 = false
-			}
-			if omitFromPC {
-				f4 = false
-			}
+		if hvp_data_point.Meta.ActivityID != "" && omitFromPC {
+			f4 = false
 		} //end of filter setup section
 
 		if filter.HeartData && !has_heart {
@@ -809,21 +766,54 @@ func hvp(user types.UserSettings, filter Filter) []Hvp {
 			hvp_data_point.AvHeartRate = user_data.Avheart
 			hvp_data_point.AvPower = user_data.Avpower
 			hvp_data_point.AvCadence = user_data.Avcad
+			hvp_data_point.Ef = user_data.Ef
+			hvp_data_point.Decoupling = user_data.Decoupling
 			hvp_data = append(hvp_data, hvp_data_point)
 		}
 	}
 	return hvp_data
 }
 
-//Tss vs Duration
-func tvd(user types.UserSettings, filter Filter) ([]Tvd, string) {
+//whether an activity passes the indoor/outdoor (first value) and race/training (second value) filters
+func metaFilter(meta ActivityMeta, filter Filter) (inOut, raceTrain bool) {
+	inOut = true
+	raceTrain = true
+	if meta.ActivityID == "" { //only filter when there IS meta data - TODO add note to user on activity page that they need to add meta for the filter to work correctly - filters are only applied when values are supplied!!!!
+		return
+	}
+	if filter.Indoor || filter.Outdoor { //filter on
+		if filter.Indoor && !filter.Outdoor && meta.OutdoorRide {
+			inOut = false
+		}
+		if !filter.Indoor && filter.Outdoor && meta.IndoorRide {
+			inOut = false
+		}
+	} else { //no user filter
+		inOut = false
+	}
+	if filter.Race || filter.Train { //filter on
+		if filter.Race && !filter.Train && meta.Train {
+			raceTrain = false
+		}
+		if !filter.Race && filter.Train && meta.Race {
+			raceTrain = false
+		}
+	} else { //no user filter
+		raceTrain = false
+	}
+	return
+}
+
+//run each of the user's activities in the period with power or heart rate data, and that pass the filter, through fn -
+//along with the session, for fetching anything else it needs
+func eachActivity(user types.UserSettings, filter Filter, fn func(session *gocql.Session, activity_id string, activity_start time.Time, user_data types.Metrics, has_power, has_heart bool)) {
 	user_id := user.Id
-	tvd_data_points := make([]Tvd_data_point, 0)
-	tvd_data := make([]Tvd, 0)
+
 	var user_data types.Metrics
 	var end_summary_json []byte
+	var has_power, has_heart bool
+	var activity_id string
 	var activity_start time.Time
-	var tvdLegend string
 
 	cluster := gocql.NewCluster(config.DbHost)
 	cluster.Keyspace = "joulepersecond"
@@ -831,152 +821,187 @@ func tvd(user types.UserSettings, filter Filter) ([]Tvd, string) {
 	session, _ := cluster.CreateSession()
 	defer session.Close()
 
-	//get all of the user's data (at least all for now) TODO limit these queries by date if poss. Done!
-	timeNow := time.Now()
+	timeNow := time.Now().AddDate(0, 0, -filter.OffsetDays) //either now (0) or user specified offset (days)
 	timeThen := timeNow.AddDate(0, 0, -filter.Historylen)
-	iter := session.Query(`SELECT activity_start, end_summary_json FROM joulepersecond.user_activity WHERE user_id = ? AND activity_start > ? ORDER BY activity_start ASC`, user_id, timeThen).Iter()
-	for iter.Scan(&activity_start, &end_summary_json) {
-		var tvd_data_point Tvd_data_point
-		json.Unmarshal(end_summary_json, &user_data)
-
-		tvd_data_point.Date = user_data.StartTime
-		tvd_data_point.Dur = user_data.Dur
-		if user_data.Utss > 0 {
-			tvd_data_point.Tss = user_data.Utss
-		} else if user_data.Tss > 0 {
-			tvd_data_point.Tss = user_data.Tss
-		} else if user_data.Etss > 0 {
-			tvd_data_point.Tss = user_data.Etss
-		} else {
-			tvd_data_point.Tss = 0
+	iter := session.Query(`SELECT activity_id, activity_start, end_summary_json, has_power, has_heart FROM joulepersecond.user_activity WHERE user_id = ? AND activity_start > ? AND activity_start <= ? ORDER BY activity_start ASC`, user_id, timeThen, timeNow).Iter()
+	for iter.Scan(&activity_id, &activity_start, &end_summary_json, &has_power, &has_heart) {
+		if !has_heart && !has_power {
+			continue
+		}
+		var meta ActivityMeta
+		session.Query(`SELECT activity_id, is_indoor, is_outdoor, is_race, is_training FROM activity_meta WHERE activity_id = ?`, activity_id).Scan(
+			&meta.ActivityID,
+			&meta.IndoorRide,
+			&meta.OutdoorRide,
+			&meta.Race,
+			&meta.Train)
+		if inOut, raceTrain := metaFilter(meta, filter); !inOut || !raceTrain {
+			continue
 		}
-		tvd_data_points = append(tvd_data_points, tvd_data_point)
-	}
-
-	//we now have all the data... Now sort it
-	sumTss := 0
-	var sumDur time.Duration
-	var lastActivity time.Time
-	//loope through each retrieved activity
-	for i := 1; i < len(tvd_data_points); i++ {
-		//set last activity on first iteration only
-		if i == 1 {
-			lastActivity = tvd_data_points[i].Date
-		}
-		//if we want to show data in monthly format
-		if filter.Historylen > 366 { //show over 365 days as monthly
-			thisDate := tvd_data_points[i].Date
-			prevDate := lastActivity
-
-			//if we're still in the current month sum these values
-			if thisDate.Month() != prevDate.Month() || i == len(tvd_data_points)-1 {
-				var summedMonthlyTvd Tvd
-				summedMonthlyTvd.TotalTss = sumTss
-				summedMonthlyTvd.TotalDur = utility.Round(sumDur.Hours(), .5, 2)
-
-				var month time.Month
-				var year string
-				if thisDate.Month() != prevDate.Month() {
-					month = prevDate.Month()
-					year = strconv.Itoa(prevDate.Year())
-				} else {
-					month = thisDate.Month()
-					year = strconv.Itoa(thisDate.Year())
-				}
-
-				monthStr := month.String()
-				summedMonthlyTvd.TimeLabel = monthStr[0:3] + " '" + year[2:4]
-
-				sumTss = 0
-				sumDur = 0
-				tvd_data = append(tvd_data, summedMonthlyTvd)
-
-				//reset the last activity date for the next loop
-				lastActivity = thisDate
-			}
-
-			sumTss += tvd_data_points[i].Tss
-			sumDur += tvd_data_points[i].Dur
-
-			tvdLegend = "By Month"
-		} else {
-			thisDate := tvd_data_points[i].Date //we use this to compare the activity being scanned with the last to see if it is in the same week
-			prevDate := lastActivity            //this is the last activity that we scanned that was the first of the new week, last week. Confusing init? we have to get the value now, and change if the weeks are not equal (new week)
-			prevIterDate := tvd_data_points[i-1].Date
 
-			//get week number for this and last activity
-			_, thisDateWeek := thisDate.ISOWeek()
-			prevDateYear, prevDateWeek := prevDate.ISOWeek()
+		user_data = types.Metrics{}
+		json.Unmarshal(end_summary_json, &user_data)
+		fn(session, activity_id, activity_start, user_data, has_power, has_heart)
+	}
+	if err := iter.Close(); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+}
 
-			if thisDateWeek != prevDateWeek || i == len(tvd_data_points)-1 {
-				var summedWeeklyTvd Tvd
-				summedWeeklyTvd.TotalTss = sumTss
-				summedWeeklyTvd.TotalDur = utility.Round(sumDur.Hours(), .5, 2)
+//Power, heart rate and cadence distributions summed over the period
+func histograms(user types.UserSettings, filter Filter) types.Histograms {
+	total := histogram.Empty(user)
+	eachActivity(user, filter, func(session *gocql.Session, activity_id string, activity_start time.Time, user_data types.Metrics, has_power, has_heart bool) {
+		//stored when the activity is processed - the time series are only needed if the user's bin widths can't be regrouped from the stored ones
+		histogram.Add(&total, histogram.ForActivity(user_data, user, func() (power_series, heart_series, cadence_series []int) {
+			var power_json, heart_json, cadence_json []byte
+			session.Query(`SELECT power_json, heart_json, cadence_json FROM joulepersecond.proc_activity WHERE activity_id = ? `, activity_id).Scan(&power_json, &heart_json, &cadence_json)
+			json.Unmarshal(power_json, &power_series)
+			json.Unmarshal(heart_json, &heart_series)
+			json.Unmarshal(cadence_json, &cadence_series)
+			return
+		}))
+	})
+	return total
+}
 
-				monthS := prevDate.Month()
+//Best power for each duration over the period, from the whole of each ride and from only the part after each of the user's work thresholds
+func durabilitycurves(user types.UserSettings, filter Filter) []types.DurabilityCurve {
+	total := durability.Empty(user)
+	eachActivity(user, filter, func(session *gocql.Session, activity_id string, activity_start time.Time, user_data types.Metrics, has_power, has_heart bool) {
+		if !has_power || (user_data.EstimatedPower && !user.UseEstimated) {
+			return
+		}
+		//stored when the activity is processed - the power series is only needed if the user has since changed their thresholds
+		durability.Add(total, durability.ForActivity(user_data, user, func() (power_series []int) {
+			var power_json []byte
+			session.Query(`SELECT power_json FROM joulepersecond.proc_activity WHERE activity_id = ? `, activity_id).Scan(&power_json)
+			json.Unmarshal(power_json, &power_series)
+			return
+		}))
+	})
+	return total
+}
 
-				var monthF time.Month
-				var dayF string
+//Time in each pedalling quadrant over the period (around the user's current FTP point)
+func quadrants(user types.UserSettings, filter Filter) quadrant.Summary {
+	total := quadrant.Empty(user)
+	eachActivity(user, filter, func(session *gocql.Session, activity_id string, activity_start time.Time, user_data types.Metrics, has_power, has_heart bool) {
+		if !has_power {
+			return
+		}
+		//each ride is split around the FTP point at the time it was ridden
+		quadrant.Add(&total, quadrant.ForActivity(user_data, user, func() (power_series, cadence_series []int, cur_ftp int) {
+			var power_json, cadence_json []byte
+			session.Query(`SELECT power_json, cadence_json, cur_ftp FROM joulepersecond.proc_activity WHERE activity_id = ? `, activity_id).Scan(&power_json, &cadence_json, &cur_ftp)
+			json.Unmarshal(power_json, &power_series)
+			json.Unmarshal(cadence_json, &cadence_series)
+			return
+		}))
+	})
+	return quadrant.Summarise(total)
+}
 
-				if thisDateWeek != prevDateWeek {
-					monthF = prevIterDate.Month()
-					dayF = strconv.Itoa(prevIterDate.Day())
+//Weekly cadence and torque in each power zone (weeks start on the user's chosen day, in their time zone - see the periods package)
+func cadencetrend(user types.UserSettings, filter Filter) []CadenceWeek {
+	cadence_data := make([]CadenceWeek, 0)
 
-				} else {
-					monthF = thisDate.Month()
-					dayF = strconv.Itoa(thisDate.Day())
-				}
+	timeNow := time.Now().AddDate(0, 0, -filter.OffsetDays) //either now (0) or user specified offset (days)
+	timeThen := timeNow.AddDate(0, 0, -filter.Historylen)
 
-				dayS := strconv.Itoa(prevDate.Day())
+	//one total per week in the period, so weeks without rides leave a gap in the trend
+	weeks := periods.Range(timeThen, timeNow, periods.Week, user.WeekStart, user.Location, false)
+	totals := make([]types.CadenceProfile, len(weeks))
+	for i := range totals {
+		totals[i] = torque.Empty(user)
+	}
 
-				monthStrS := monthS.String()
-				monthAbrS := monthStrS[0:3]
-				monthStrF := monthF.String()
-				monthAbrF := monthStrF[0:3]
+	eachActivity(user, filter, func(session *gocql.Session, activity_id string, activity_start time.Time, user_data types.Metrics, has_power, has_heart bool) {
+		if !has_power {
+			return
+		}
+		i := periods.Index(weeks, activity_start)
+		if i < 0 {
+			return
+		}
+		//worked out when the activity is processed - the time series are only needed if the user has since changed their power zones
+		torque.Add(&totals[i], torque.ForActivity(user_data, user, func() (power_series, cadence_series []int, cur_ftp int) {
+			var power_json, cadence_json []byte
+			session.Query(`SELECT power_json, cadence_json, cur_ftp FROM joulepersecond.proc_activity WHERE activity_id = ? `, activity_id).Scan(&power_json, &cadence_json, &cur_ftp)
+			json.Unmarshal(power_json, &power_series)
+			json.Unmarshal(cadence_json, &cadence_series)
+			return
+		}))
+	})
 
-				//format labels according to number to displau
-				if filter.Historylen < 120 {
-					summedWeeklyTvd.TimeLabel = dayS + " " + monthAbrS + " - " + dayF + " " + monthAbrF
-				} else {
-					summedWeeklyTvd.TimeLabel = dayS + " " + monthAbrS
-				}
+	for i, week := range weeks {
+		cadence_data = append(cadence_data, CadenceWeek{TimeLabel: week.Label, Bands: totals[i].Bands})
+	}
+	return cadence_data
+}
 
-				sumTss = 0
-				sumDur = 0
-				tvd_data = append(tvd_data, summedWeeklyTvd)
+//sort climbs so repeat ascents of the same hill sit together (in date order)
+type ByAscent []climb.Climb
 
-				//reset the last activity date for the next loop
-				lastActivity = thisDate
+func (a ByAscent) Len() int      { return len(a) }
+func (a ByAscent) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByAscent) Less(i, j int) bool {
+	if a[i].Ascent != a[j].Ascent {
+		return a[i].Ascent < a[j].Ascent
+	}
+	return a[i].ActivityStart.Before(a[j].ActivityStart)
+}
 
-			}
+//The user's climbs over the period from the climbs table
+func climbs(user types.UserSettings, filter Filter) []climb.Climb {
+	user_id := user.Id
+	climb_data := make([]climb.Climb, 0)
 
-			//sum the values
-			sumTss += tvd_data_points[i].Tss
-			sumDur += tvd_data_points[i].Dur
+	var activity_id string
+	var climb_json []byte
 
-			tvdLegend = "By week number: Series ending wk" + strconv.Itoa(prevDateWeek) + ", " + strconv.Itoa(prevDateYear)
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
 
+	timeNow := time.Now().AddDate(0, 0, -filter.OffsetDays) //either now (0) or user specified offset (days)
+	timeThen := timeNow.AddDate(0, 0, -filter.Historylen)
+	iter := session.Query(`SELECT activity_id, climb_json FROM joulepersecond.user_climb WHERE user_id = ? AND activity_start > ? AND activity_start <= ? ORDER BY activity_start ASC`, user_id, timeThen, timeNow).Iter()
+	for iter.Scan(&activity_id, &climb_json) {
+		var meta ActivityMeta
+		session.Query(`SELECT activity_id, activity_name, is_indoor, is_outdoor, is_race, is_training FROM activity_meta WHERE activity_id = ?`, activity_id).Scan(
+			&meta.ActivityID,
+			&meta.ActivityName,
+			&meta.IndoorRide,
+			&meta.OutdoorRide,
+			&meta.Race,
+			&meta.Train)
+		if inOut, raceTrain := metaFilter(meta, filter); !inOut || !raceTrain {
+			continue
 		}
+		var c climb.Climb
+		json.Unmarshal(climb_json, &c)
+		climb_data = append(climb_data, c)
 	}
-	return tvd_data, tvdLegend
+	if err := iter.Close(); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	climb_data = climb.Group(climb_data)
+	sort.Sort(ByAscent(climb_data))
+	return climb_data
 }
 
-//fitness and freshness
-func ff(user types.UserSettings, filter Filter) []types.Ff_data_point {
+//Tss vs Duration
+func tvd(user types.UserSettings, filter Filter) ([]Tvd, string) {
 	user_id := user.Id
-	ff_data := make([]types.Ff_data_point, 0)      //activity day
-	ff_scan_data := make([]types.Ff_data_point, 0) //all days in range
-
+	tvd_data_points := make([]Tvd_data_point, 0)
+	tvd_data := make([]Tvd, 0)
 	var user_data types.Metrics
-	var user_cpms types.CPMs
 	var end_summary_json []byte
-	var cp_data_json []byte
-	var has_power, has_heart bool
 	var activity_start time.Time
-	var firstDate time.Time
-	var firstIter = true
-
-	var activity_id string
+	var tvdLegend string
 
 	cluster := gocql.NewCluster(config.DbHost)
 	cluster.Keyspace = "joulepersecond"
@@ -984,137 +1009,217 @@ func ff(user types.UserSettings, filter Filter) []types.Ff_data_point {
 	session, _ := cluster.CreateSession()
 	defer session.Close()
 
-	var lastNotableCp float64
-	var thisCp int
-
-	//get all of the user's data (at least all for now) TODO limit these queries by date if poss.
-	iter := session.Query(`SELECT activity_start, activity_id, end_summary_json, has_power, has_heart FROM joulepersecond.user_activity WHERE user_id = ? ORDER BY activity_start ASC`, user_id).Iter()
-	//loop through each activity
-	for iter.Scan(&activity_start, &activity_id, &end_summary_json, &has_power, &has_heart) {
-		iter := session.Query(`SELECT cp_data_json FROM joulepersecond.proc_activity WHERE activity_id = ? LIMIT 1`, activity_id).Iter()
-		for iter.Scan(&cp_data_json) {
-			json.Unmarshal(cp_data_json, &user_cpms)
-
-		}
-		if firstIter {
-			firstDate = activity_start
-			firstIter = false
-		}
-		var ff_data_point types.Ff_data_point
-		//unmarshal the activtiy types.Metrics
+	//get all of the user's data (at least all for now) TODO limit these queries by date if poss. Done!
+	timeNow := time.Now()
+	timeThen := timeNow.AddDate(0, 0, -filter.Historylen)
+	iter := session.Query(`SELECT activity_start, end_summary_json FROM joulepersecond.user_activity WHERE user_id = ? AND activity_start > ? ORDER BY activity_start ASC`, user_id, timeThen).Iter()
+	for iter.Scan(&activity_start, &end_summary_json) {
+		var tvd_data_point Tvd_data_point
+		user_data = types.Metrics{} //clear the last activity's values (the load map would otherwise be merged)
 		json.Unmarshal(end_summary_json, &user_data)
 
-		//values for each scanned activity
-		var user_tss int
-		var perc_effort int
-		var mot_level int
-
-		//check for a tss override value
-		session.Query(`SELECT activity_id, tss_value, motivation_level, perceived_effort FROM activity_meta WHERE activity_id = ?`, activity_id).Scan(&activity_id, &user_tss, &mot_level, &perc_effort)
-
-		//get a value for tss whether from hr, power or (TODO user set)
-		if user_tss > 0 {
-			ff_data_point.Tss = user_tss
+		tvd_data_point.Date = activity_start
+		tvd_data_point.Dur = user_data.Dur
+		if user_data.Utss > 0 {
+			tvd_data_point.Tss = user_data.Utss
 		} else {
-			if has_power {
-				ff_data_point.Tss = user_data.Tss
-			} else if has_heart {
-				ff_data_point.Tss = user_data.Etss
-			}
+			tvd_data_point.Tss = loadmetric.Value(user_data, user)
 		}
+		tvd_data_points = append(tvd_data_points, tvd_data_point)
+	}
 
-		if filter.S5 {
-			thisCp = user_cpms.FiveSecondCP
-		} else if filter.S20 {
-			thisCp = user_cpms.TwentySecondCP
-		} else if filter.S60 {
-			thisCp = user_cpms.SixtySecondCP
-		} else if filter.S300 {
-			thisCp = user_cpms.FiveMinuteCP
-		} else if filter.S1200 {
-			thisCp = user_cpms.TwentyMinuteCP
-		} else if filter.S3600 {
-			thisCp = user_cpms.SixtyMinuteCP
+	//we now have all the data... Now sum it by calendar period (see the periods package), every period in the range getting a bar
+	unit := periods.Unit(filter.Period, filter.Historylen)
+	buckets := periods.Range(timeThen, timeNow, unit, user.WeekStart, user.Location, filter.Historylen < 120)
+	sumTss := make([]int, len(buckets))
+	sumDur := make([]time.Duration, len(buckets))
+	for _, point := range tvd_data_points {
+		if i := periods.Index(buckets, point.Date); i >= 0 {
+			sumTss[i] += point.Tss
+			sumDur[i] += point.Dur
 		}
+	}
+	for i, bucket := range buckets {
+		tvd_data = append(tvd_data, Tvd{TimeLabel: bucket.Label, TotalTss: sumTss[i], TotalDur: utility.Round(sumDur[i].Hours(), .5, 2)})
+	}
+	tvdLegend = periods.Legend(unit, user.WeekStart)
 
-		if float64(thisCp) > lastNotableCp {
-			lastNotableCp = float64(thisCp)
-			ff_data_point.NotableCp = float64(thisCp)
+	return tvd_data, tvdLegend
+}
 
-		} else {
-			lastNotableCp = lastNotableCp * float64(user.Ncp_rolloff) / 1000 //0.995 default
-		}
+//a warning threshold from the filter, the default where not given
+func warning(val string, def float64) float64 {
+	threshold, err := strconv.ParseFloat(val, 64)
+	if err != nil || threshold <= 0 {
+		return def
+	}
+	return threshold
+}
 
-		//get the date
-		ff_data_point.Date = user_data.StartTime
+//fitness and freshness as JSON, along with the overload indicators
+//
+//	GET /ff/<token>?history-len=&event-date=&plan-tss=
+func FfHandler(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token")
 
-		//add extended metrics
-		ff_data_point.Meta.MotivationLevel = mot_level
-		ff_data_point.Meta.PerceivedEffort = perc_effort
+	urlparts := strings.Split(r.URL.Path[1:], "/")
+	if len(urlparts) < 2 || urlparts[1] == "" {
+		http.Error(w, "Malformed URL", http.StatusBadRequest)
+		return
+	}
+	access_token, err := url.QueryUnescape(urlparts[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := Usersettings.Get(access_token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
-		//add date and tss to array slice
-		ff_data = append(ff_data, ff_data_point)
+	var filter Filter
+	filter.Historylen = history_days
+	filter.S3600 = true
+	//longer histories are for subscribers only
+	if hisLen, err := strconv.Atoi(r.FormValue("history-len")); err == nil && (hisLen <= history_days || user.Paid_account) {
+		filter.Historylen = hisLen
+	}
+	if eventDate, err := time.ParseInLocation(plan.DateFormat, r.FormValue("event-date"), user.Location); err == nil {
+		filter.EventDate = eventDate
+	}
+	if planTss, err := strconv.Atoi(r.FormValue("plan-tss")); err == nil && planTss > 0 {
+		filter.PlanTss = planTss
+	}
 
+	ffData, forecastDays := ff(user, filter)
+	if len(ffData) > (filter.Historylen + forecastDays) {
+		ffData = ffData[len(ffData)-(filter.Historylen+forecastDays):]
 	}
-	if err := iter.Close(); err != nil {
-		log.Fatal(err)
+	data, err := json.Marshal(ffData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	//calulate the number of days between first recorded activity and current date
-	duration := time.Since(firstDate) //get total duration in time.Duration
-	days := int(duration/day) + 1     //add one day to include current day
-
-	//loop through each day.
-	for i := 0; i <= days+30; i++ { //add 30 days to show roll off
-		//create a var to hold a sample for each day scanned - it may or maynot contain an activity, but will still contain ctl, atl and tsb vals along with the date
-		var scan_data_point types.Ff_data_point
-
-		//get each day to be scanned (all days between first activity and current date)
-		scanDate := firstDate.AddDate(0, 0, i)
-		scan_data_point.Date = scanDate                 //get the date
-		scanYear, scanMonth, scanDay := scanDate.Date() //get date elements that are easier to compare
-
-		//these so we can print out UTC values to Highcharts
-		scan_data_point.Day = scanDay
-		scan_data_point.Month = int(scanMonth) - 1 //js months start at naught.
-		scan_data_point.Year = scanYear
-
-		//now get each of the days where we have an activity
-		for _, val := range ff_data {
-			actYear, actMonth, actDay := val.Date.Date()
-			if actYear == scanYear && actMonth == scanMonth && actDay == scanDay {
-				scan_data_point.Meta.PerceivedEffort = val.Meta.PerceivedEffort
-				scan_data_point.Meta.MotivationLevel = val.Meta.MotivationLevel
-				//these are the days where we have activites - add the TSS
-
-				//we might have more than one activity in a day so append any others TSS on this day
-				scan_data_point.Tss += val.Tss
-				if val.NotableCp > 0 {
-					scan_data_point.NotableCp = val.NotableCp
-					scan_data_point.HasValue = true
-				} else {
-					scan_data_point.HasValue = false
-				}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+//fitness and freshness from the stored daily load, carried on to today and projected forecastDays ahead
+func ff(user types.UserSettings, filter Filter) (ff_scan_data []types.Ff_data_point, forecastDays int) {
+	ff_scan_data = make([]types.Ff_data_point, 0) //all days in range
+
+	var lastNotableCp float64
+	var thisCp int
 
+	//the stored days (see the dailyload package), picking out the notable CPs for the filter's duration
+	for _, stored := range dailyload.Get(user) {
+		scan_data_point := stored.Ff_data_point
+		for _, user_cpms := range stored.Cps {
+			if filter.S5 {
+				thisCp = user_cpms.FiveSecondCP
+			} else if filter.S20 {
+				thisCp = user_cpms.TwentySecondCP
+			} else if filter.S60 {
+				thisCp = user_cpms.SixtySecondCP
+			} else if filter.S300 {
+				thisCp = user_cpms.FiveMinuteCP
+			} else if filter.S1200 {
+				thisCp = user_cpms.TwentyMinuteCP
+			} else if filter.S3600 {
+				thisCp = user_cpms.SixtyMinuteCP
 			}
-			//else leave TSS as it is (0)
-		}
-		if i > 0 { //don't have a yesterday value on the first day
-			scan_data_point.Atl = ff_scan_data[i-1].Atl + (float64(scan_data_point.Tss)-ff_scan_data[i-1].Atl)/float64(user.Atl_constant)
-			scan_data_point.Ctl = ff_scan_data[i-1].Ctl + (float64(scan_data_point.Tss)-ff_scan_data[i-1].Ctl)/float64(user.Ctl_constant)
-			scan_data_point.Tsb = scan_data_point.Ctl - scan_data_point.Atl
-			//fmt.Printf("Atl: %v Ctl: %v Tsb: %v\n", scan_data_point.Atl, scan_data_point.Ctl, scan_data_point.Tsb)
-		}
 
-		//add the data to our array slice
+			if float64(thisCp) > lastNotableCp {
+				lastNotableCp = float64(thisCp)
+				scan_data_point.NotableCp = float64(thisCp)
+				scan_data_point.HasValue = true
+			} else {
+				lastNotableCp = lastNotableCp * float64(user.Ncp_rolloff) / 1000 //0.995 default
+			}
+		}
 		ff_scan_data = append(ff_scan_data, scan_data_point)
+	}
+	if len(ff_scan_data) == 0 {
+		return
+	}
 
+	//project forward to the event date (or 30 days) from the planned load - days without a plan get the filter's daily TSS
+	today := time.Now().In(user.Location).Format(plan.DateFormat)
+	forecastDays = 30
+	if !filter.EventDate.IsZero() {
+		if eventDays := int(filter.EventDate.Sub(time.Now())/day) + 1; eventDays > 0 {
+			forecastDays = eventDays
+		}
 	}
-	//OPTION (show reduced results) commenting out the follwing lines bypasses filter
-	if len(ff_scan_data) > (filter.Historylen + 30) {
-		ff_scan_data = ff_scan_data[len(ff_scan_data)-(filter.Historylen+30) : len(ff_scan_data)]
+	planned := plan.Daily(plan.Get(user, time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, forecastDays+1)))
+
+	//carry on from the last stored day (without load up to today)
+	lastDate := dailyload.DayOf(time.Now(), user.Location).AddDate(0, 0, forecastDays)
+	for scanDate := ff_scan_data[len(ff_scan_data)-1].Date.AddDate(0, 0, 1); !scanDate.After(lastDate); scanDate = scanDate.AddDate(0, 0, 1) {
+		scan_data_point := types.Ff_data_point{Date: scanDate}
+		if scanKey := scanDate.Format(plan.DateFormat); scanKey > today {
+			scan_data_point.Forecast = true
+			if tss, ok := planned[scanKey]; ok {
+				scan_data_point.Tss = tss
+			} else {
+				scan_data_point.Tss = filter.PlanTss
+			}
+		}
+		dailyload.Step(ff_scan_data[len(ff_scan_data)-1], &scan_data_point, user)
+		ff_scan_data = append(ff_scan_data, scan_data_point)
+	}
+
+	//these so we can print out UTC values to Highcharts
+	for i := range ff_scan_data {
+		scanYear, scanMonth, scanDay := ff_scan_data[i].Date.Date()
+		ff_scan_data[i].Day = scanDay
+		ff_scan_data[i].Month = int(scanMonth) - 1 //js months start at naught.
+		ff_scan_data[i].Year = scanYear
 	}
+	dailyload.Trends(ff_scan_data)
 
-	return ff_scan_data
+	return
+}
+
+//the Banister model fitted to the whole load history and its notable CPs, the predicted performance over the last shown days and a taper for the event date
+func performance(series []types.Ff_data_point, filter Filter, shown int) (model banister.Model, curve []PerformancePoint, taper banister.Taper) {
+	loads := make([]float64, len(series))
+	performances := make([]float64, len(series))
+	today, target := -1, -1
+	for t, point := range series {
+		loads[t] = float64(point.Tss)
+		if point.Forecast {
+			if !filter.EventDate.IsZero() && point.Date.Format(plan.DateFormat) == filter.EventDate.Format(plan.DateFormat) {
+				target = t
+			}
+			continue
+		}
+		today = t
+		performances[t] = point.NotableCp
+	}
+	model = banister.Fit(loads, performances)
+	if !model.Fitted {
+		return
+	}
+	predicted := model.Predict(loads)
+	curve = make([]PerformancePoint, 0)
+	from := len(series) - shown
+	if from < 0 {
+		from = 0
+	}
+	for t := from; t < len(series); t++ {
+		curve = append(curve, PerformancePoint{Year: series[t].Year, Month: series[t].Month, Day: series[t].Day, Predicted: predicted[t], Measured: performances[t], Forecast: series[t].Forecast})
+	}
+	if target > 0 {
+		taper = banister.SuggestTaper(model, loads, today, target)
+	}
+	return
 }
 
 //combines the standard CpRow with the date of the sample
@@ -1142,6 +1247,7 @@ func powercurve(user types.UserSettings, filter Filter) ([]Cp3, Cp3Legend) {
 	var power_series []byte
 	var cp_row_json []byte
 	var has_power bool
+	var quality_score *int //nil for activities processed before scoring (0 is a real score - every second corrected or flagged)
 	var cpRows []CpRow
 
 	powerSeries := make([]int, 0)
@@ -1166,10 +1272,20 @@ func powercurve(user types.UserSettings, filter Filter) ([]Cp3, Cp3Legend) {
 	for iter.Scan(&activity_id) {
 		var merged CpMerged //to temporarily store each merged cpRow/label
 		//var overwritten bool //if value has to be written if not an overwrite
-		session.Query(`SELECT end_summary_json, power_json, cp_row_json, has_power FROM proc_activity WHERE activity_id = ?`, activity_id).Scan(&end_summary, &power_series, &cp_row_json, &has_power)
+		quality_score = nil
+		session.Query(`SELECT end_summary_json, power_json, cp_row_json, has_power, quality_score FROM proc_activity WHERE activity_id = ?`, activity_id).Scan(&end_summary, &power_series, &cp_row_json, &has_power, &quality_score)
+		//skip activities with poor data (activities processed before scoring have no score)
+		if quality_score != nil && *quality_score < filter.MinQuality {
+			continue
+		}
 		json.Unmarshal(power_series, &powerSeries)
+		endSummary = types.Metrics{}
 		json.Unmarshal(end_summary, &endSummary)
 		json.Unmarshal(cp_row_json, &cpRows)
+		//rides processed with estimated power only count if the user (still) wants them to
+		if endSummary.EstimatedPower && !user.UseEstimated {
+			continue
+		}
 
 		//calculate age of activity for assignment to each quadrant
 		activityAge := int((time.Since(endSummary.StartTime)) / day) //age of activity in days... Might need to add one(+1) to include full period
@@ -1252,80 +1368,387 @@ func powercurve(user types.UserSettings, filter Filter) ([]Cp3, Cp3Legend) {
 	sort.Sort(ByTimecode(mergedCpRow_2))
 	sort.Sort(ByTimecode(mergedCpRow_3))
 
-	//function to merge the three date ranged structs into one
-	mergeToLargest := func(largest, series2, series3 []CpMerged) []Cp3 {
-		var dateRangedCpRow Cp3
+	//merge the three date ranged series into one row per duration - values are paired on duration (not position in the series) so the lines stay aligned
+	mergeByDuration := func(series1, series2, series3 []CpMerged) []Cp3 {
+		byDuration := make(map[int]*Cp3)
+		durations := make([]int, 0)
+		row := func(cpTime int) *Cp3 {
+			if _, ok := byDuration[cpTime]; !ok {
+				byDuration[cpTime] = &Cp3{CpTime: cpTime}
+				durations = append(durations, cpTime)
+			}
+			return byDuration[cpTime]
+		}
+		for _, merged := range series1 {
+			dateRangedCpRow := row(merged.CpTime)
+			dateRangedCpRow.CpPower1 = merged.CpRow.CpVal
+			dateRangedCpRow.CpLabel1 = merged.CpLabel
+		}
+		for _, merged := range series2 {
+			dateRangedCpRow := row(merged.CpTime)
+			dateRangedCpRow.CpPower2 = merged.CpRow.CpVal
+			dateRangedCpRow.CpLabel2 = merged.CpLabel
+		}
+		for _, merged := range series3 {
+			dateRangedCpRow := row(merged.CpTime)
+			dateRangedCpRow.CpPower3 = merged.CpRow.CpVal
+			dateRangedCpRow.CpLabel3 = merged.CpLabel
+		}
+		sort.Ints(durations)
 		dateRangedCpRows := make([]Cp3, 0)
-		//loop through largest file and copy required contents to new single struct
-		for i := 0; i < len(largest); i++ {
-			dateRangedCpRow.CpTime = largest[i].CpTime
+		for _, cpTime := range durations {
+			dateRangedCpRows = append(dateRangedCpRows, *byDuration[cpTime])
+		}
+		return dateRangedCpRows
+	}
 
-			dateRangedCpRow.CpPower1 = largest[i].CpRow.CpVal
-			dateRangedCpRow.CpLabel1 = largest[i].CpLabel
+	var legends Cp3Legend
+	legends.Series1 = "Last " + strconv.Itoa(filter.Historylen) + " Days"
+	legends.Series2 = strconv.Itoa(filter.Historylen) + " to " + strconv.Itoa(filter.Historylen*2) + " Days ago"
+	legends.Series3 = strconv.Itoa(filter.Historylen*2) + " to " + strconv.Itoa(filter.Historylen*3) + " Days ago"
+	allCpData := mergeByDuration(mergedCpRow_1, mergedCpRow_2, mergedCpRow_3)
 
-			if i < len(series2) {
-				dateRangedCpRow.CpPower2 = series2[i].CpRow.CpVal
-				dateRangedCpRow.CpLabel2 = series2[i].CpLabel
-			} else {
-				dateRangedCpRow.CpPower2 = 0
-				dateRangedCpRow.CpLabel2 = ""
+	mergedCpRows = append(mergedCpRows, mergedCpRow_1)
+	mergedCpRows = append(mergedCpRows, mergedCpRow_2)
+	mergedCpRows = append(mergedCpRows, mergedCpRow_3)
+
+	if err := iter.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	return allCpData, legends
+}
+
+//the rider's power profile over the period and for each month in it - each ride's bests are in W/kg at the weight on the ride date
+func profiles(user types.UserSettings, filter Filter) (profile.Profile, []profile.Profile) {
+	user_id := user.Id
+	trend := make([]profile.Profile, 0)
+
+	var user_data types.Metrics
+	var end_summary_json []byte
+	var cp_data_json []byte
+	var has_power bool
+	var activity_id string
+	var activity_start time.Time
+	var cpms types.CPMs
+
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	timeNow := time.Now().AddDate(0, 0, -filter.OffsetDays) //either now (0) or user specified offset (days)
+	timeThen := timeNow.AddDate(0, 0, -filter.Historylen)
+
+	//one set of bests per month in the period
+	monthStart := func(date time.Time) time.Time {
+		return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	}
+	months := make([]time.Time, 0)
+	monthly := make(map[time.Time]*[4]float64)
+	for month := monthStart(timeThen); !month.After(timeNow); month = month.AddDate(0, 1, 0) {
+		months = append(months, month)
+		monthly[month] = &[4]float64{}
+	}
+	var total [4]float64
+	weights := weight.Log(user, time.Time{}, timeNow)
+
+	iter := session.Query(`SELECT activity_id, activity_start, end_summary_json, has_power FROM joulepersecond.user_activity WHERE user_id = ? AND activity_start > ? AND activity_start <= ? ORDER BY activity_start ASC`, user_id, timeThen, timeNow).Iter()
+	for iter.Scan(&activity_id, &activity_start, &end_summary_json, &has_power) {
+		if !has_power {
+			continue
+		}
+		var meta ActivityMeta
+		var activity_weight, activity_ftp int
+		session.Query(`SELECT activity_id, is_indoor, is_outdoor, is_race, is_training, activity_weight, activity_ftp FROM activity_meta WHERE activity_id = ?`, activity_id).Scan(
+			&meta.ActivityID,
+			&meta.IndoorRide,
+			&meta.OutdoorRide,
+			&meta.Race,
+			&meta.Train,
+			&activity_weight,
+			&activity_ftp)
+		if inOut, raceTrain := metaFilter(meta, filter); !inOut || !raceTrain {
+			continue
+		}
+		user_data = types.Metrics{}
+		json.Unmarshal(end_summary_json, &user_data)
+		if user_data.EstimatedPower && !user.UseEstimated {
+			continue
+		}
+		//the weight log first, then the weight the ride was processed with, then the current weight
+		rideWeight := weight.On(weights, activity_start)
+		if rideWeight == 0 {
+			rideWeight = float64(activity_weight)
+		}
+		if rideWeight == 0 {
+			rideWeight = float64(user.Weight)
+		}
+		cpms = types.CPMs{}
+		session.Query(`SELECT cp_data_json FROM joulepersecond.proc_activity WHERE activity_id = ? `, activity_id).Scan(&cp_data_json)
+		json.Unmarshal(cp_data_json, &cpms)
+
+		wkg := profile.Wkg(cpms, rideWeight, activity_ftp)
+		profile.Add(&total, wkg)
+		if month, ok := monthly[monthStart(activity_start.In(timeNow.Location()))]; ok {
+			profile.Add(month, wkg)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+
+	for _, month := range months {
+		trend = append(trend, profile.Classify(month.Format("Jan 2006"), *monthly[month], user.Gender))
+	}
+	return profile.Classify("Last "+strconv.Itoa(filter.Historylen)+" Days", total, user.Gender), trend
+}
+
+//best power for each duration over each of the filter's date ranges, and for the overlay activity, on a shared set of durations
+func comparecurves(user types.UserSettings, filter Filter) CurveComparison {
+	var comparison CurveComparison
+	var activity_id string
+	var title string
+	var end_summary []byte
+	var endSummary types.Metrics
+	var cp_row_json []byte
+	var has_power bool
+	var quality_score *int //nil for activities processed before scoring (0 is a real score - every second corrected or flagged)
+	var cpRows []CpRow
+
+	const longForm = "Mon&nbsp;Jan&nbsp;2,&nbsp;2006&nbsp;3:04pm"
+
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	//the best of each duration, keyed by duration in seconds
+	curves := make([]map[int]CpMerged, 0)
+	names := make([]string, 0)
+
+	//keep an activity's values where they beat the curve's
+	merge := func(curve map[int]CpMerged) {
+		for _, cpRow := range cpRows {
+			cpTime := (cpRow.CpTime[0] * 3600) + (cpRow.CpTime[1] * 60) + cpRow.CpTime[2]
+			if cpRow.CpVal > curve[cpTime].CpRow.CpVal {
+				curve[cpTime] = CpMerged{CpRow: cpRow, CpTime: cpTime, CpLabel: endSummary.StartTime.Format(longForm)}
 			}
+		}
+	}
 
-			if i < len(series3) {
-				dateRangedCpRow.CpPower3 = series3[i].CpRow.CpVal
-				dateRangedCpRow.CpLabel3 = series3[i].CpLabel
-			} else {
-				dateRangedCpRow.CpPower3 = 0
-				dateRangedCpRow.CpLabel3 = ""
+	for _, dateRange := range filter.Ranges {
+		curve := make(map[int]CpMerged)
+		iter := session.Query(`SELECT activity_id FROM joulepersecond.user_activity WHERE user_id = ? AND activity_start >= ? AND activity_start < ? ORDER BY activity_start ASC`, user.Id, dateRange.From, dateRange.To.AddDate(0, 0, 1)).Iter()
+		for iter.Scan(&activity_id) {
+			quality_score = nil
+			has_power = false
+			session.Query(`SELECT end_summary_json, cp_row_json, has_power, quality_score FROM proc_activity WHERE activity_id = ?`, activity_id).Scan(&end_summary, &cp_row_json, &has_power, &quality_score)
+			//same rules as powercurve()
+			if !has_power || (quality_score != nil && *quality_score < filter.MinQuality) {
+				continue
 			}
-			dateRangedCpRows = append(dateRangedCpRows, dateRangedCpRow)
+			endSummary = types.Metrics{}
+			json.Unmarshal(end_summary, &endSummary)
+			if endSummary.EstimatedPower && !user.UseEstimated {
+				continue
+			}
+			cpRows = nil
+			json.Unmarshal(cp_row_json, &cpRows)
+			merge(curve)
+		}
+		if err := iter.Close(); err != nil {
+			fmt.Printf("%v\n", err)
+		}
+		curves = append(curves, curve)
+		names = append(names, dateRange.Name)
+	}
 
+	if filter.Overlay != "" {
+		curve := make(map[int]CpMerged)
+		if err := session.Query(`SELECT title, end_summary_json, cp_row_json FROM proc_activity WHERE activity_id = ?`, filter.Overlay).Scan(&title, &end_summary, &cp_row_json); err == nil {
+			endSummary = types.Metrics{}
+			json.Unmarshal(end_summary, &endSummary)
+			cpRows = nil
+			json.Unmarshal(cp_row_json, &cpRows)
+			merge(curve)
+			curves = append(curves, curve)
+			names = append(names, title)
 		}
-		return dateRangedCpRows
 	}
-	var allCpData []Cp3
 
-	var legends Cp3Legend
+	//every duration any of the curves has a value for
+	for _, curve := range curves {
+		for cpTime := range curve {
+			comparison.Durations = append(comparison.Durations, cpTime)
+		}
+	}
+	sort.Ints(comparison.Durations)
+	durations := make([]int, 0)
+	for i, cpTime := range comparison.Durations {
+		if i == 0 || cpTime != comparison.Durations[i-1] {
+			durations = append(durations, cpTime)
+		}
+	}
+	comparison.Durations = durations
 
-	s1 := "Last " + strconv.Itoa(filter.Historylen) + " Days"
-	s2 := strconv.Itoa(filter.Historylen) + " to " + strconv.Itoa(filter.Historylen*2) + " Days ago"
-	s3 := strconv.Itoa(filter.Historylen*2) + " to " + strconv.Itoa(filter.Historylen*3) + " Days ago"
-
-	//find the largest data series TODO need further ordering to that legend corresponds to correct series
-	if len(mergedCpRow_1) >= len(mergedCpRow_2) && len(mergedCpRow_1) >= len(mergedCpRow_3) {
-		allCpData = mergeToLargest(mergedCpRow_1, mergedCpRow_2, mergedCpRow_3)
-		legends.Series1 = s1
-		legends.Series2 = s2
-		legends.Series3 = s3
-	} else if len(mergedCpRow_2) >= len(mergedCpRow_1) && len(mergedCpRow_2) >= len(mergedCpRow_3) {
-		allCpData = mergeToLargest(mergedCpRow_2, mergedCpRow_1, mergedCpRow_3)
-		legends.Series1 = s2
-		legends.Series2 = s1
-		legends.Series3 = s3
-	} else if len(mergedCpRow_3) >= len(mergedCpRow_1) && len(mergedCpRow_3) >= len(mergedCpRow_2) {
-		allCpData = mergeToLargest(mergedCpRow_3, mergedCpRow_1, mergedCpRow_2)
-		legends.Series1 = s3
-		legends.Series2 = s1
-		legends.Series3 = s2
+	for c, curve := range curves {
+		series := CurveSeries{Name: names[c]}
+		for _, cpTime := range comparison.Durations {
+			series.Watts = append(series.Watts, curve[cpTime].CpRow.CpVal)
+			series.Labels = append(series.Labels, curve[cpTime].CpLabel)
+		}
+		comparison.Series = append(comparison.Series, series)
 	}
+	return comparison
+}
 
-	mergedCpRows = append(mergedCpRows, mergedCpRow_1)
-	mergedCpRows = append(mergedCpRows, mergedCpRow_2)
-	mergedCpRows = append(mergedCpRows, mergedCpRow_3)
+//mean maximal heart rate and cadence curves, merged across the filtered activities for three periods of the history length ending at the offset
+func meanmaxcurve(user types.UserSettings, filter Filter) ([]Mm3, Cp3Legend) {
+	user_id := user.Id
+
+	var activity_id string
+	var activity_start time.Time
+	var cp_row_json []byte
+	var has_heart, has_cadence bool
+	var quality_score *int //nil for activities processed before scoring (0 is a real score - every second corrected or flagged)
+	var cpRows []CpRow
+	var legends Cp3Legend
+
+	best := make(map[int]*Mm3) //best values keyed by duration (seconds)
+
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	timeNow := time.Now().AddDate(0, 0, -filter.OffsetDays) //either now (0) or user specified offset (days)
+	timeThen := timeNow.AddDate(0, 0, -filter.Historylen*3)
 
+	iter := session.Query(`SELECT activity_id, activity_start FROM joulepersecond.user_activity WHERE user_id = ? AND activity_start > ? AND activity_start <= ? ORDER BY activity_start ASC`, user_id, timeThen, timeNow).Iter()
+	for iter.Scan(&activity_id, &activity_start) {
+		var meta ActivityMeta
+		session.Query(`SELECT activity_id, is_indoor, is_outdoor, is_race, is_training FROM activity_meta WHERE activity_id = ?`, activity_id).Scan(
+			&meta.ActivityID,
+			&meta.IndoorRide,
+			&meta.OutdoorRide,
+			&meta.Race,
+			&meta.Train)
+		if inOut, raceTrain := metaFilter(meta, filter); !inOut || !raceTrain {
+			continue
+		}
+
+		quality_score = nil
+		session.Query(`SELECT cp_row_json, has_heart, has_cadence, quality_score FROM proc_activity WHERE activity_id = ?`, activity_id).Scan(&cp_row_json, &has_heart, &has_cadence, &quality_score)
+		if !has_heart && !has_cadence {
+			continue
+		}
+		//skip activities with poor data (activities processed before scoring have no score)
+		if quality_score != nil && *quality_score < filter.MinQuality {
+			continue
+		}
+		cpRows = nil
+		json.Unmarshal(cp_row_json, &cpRows)
+
+		//which of the three periods the activity belongs to
+		period := 0
+		if filter.Historylen > 0 {
+			period = int(timeNow.Sub(activity_start)/day) / filter.Historylen
+		}
+		if period > 2 {
+			continue
+		}
+
+		for _, row := range cpRows {
+			secs := (row.CpTime[0] * 3600) + (row.CpTime[1] * 60) + row.CpTime[2]
+			if best[secs] == nil {
+				best[secs] = &Mm3{CpTime: secs}
+			}
+			mm := best[secs]
+			hr, cad := row.HrVal, row.CadVal
+			if !has_heart {
+				hr = 0
+			}
+			if !has_cadence {
+				cad = 0
+			}
+			switch period {
+			case 0:
+				mm.Hr1 = maxInt(mm.Hr1, hr)
+				mm.Cad1 = maxInt(mm.Cad1, cad)
+			case 1:
+				mm.Hr2 = maxInt(mm.Hr2, hr)
+				mm.Cad2 = maxInt(mm.Cad2, cad)
+			case 2:
+				mm.Hr3 = maxInt(mm.Hr3, hr)
+				mm.Cad3 = maxInt(mm.Cad3, cad)
+			}
+		}
+	}
 	if err := iter.Close(); err != nil {
-		log.Fatal(err)
+		fmt.Printf("%v\n", err)
 	}
 
-	return allCpData, legends
+	durations := make([]int, 0)
+	for secs := range best {
+		durations = append(durations, secs)
+	}
+	sort.Ints(durations)
+	mmData := make([]Mm3, 0)
+	for _, secs := range durations {
+		mmData = append(mmData, *best[secs])
+	}
+
+	//the periods end at the offset
+	legend := func(period int) string {
+		from, to := filter.OffsetDays+filter.Historylen*period, filter.OffsetDays+filter.Historylen*(period+1)
+		if from == 0 {
+			return "Last " + strconv.Itoa(to) + " Days"
+		}
+		return strconv.Itoa(from) + " to " + strconv.Itoa(to) + " Days ago"
+	}
+	legends.Series1 = legend(0)
+	legends.Series2 = legend(1)
+	legends.Series3 = legend(2)
+
+	return mmData, legends
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
 
 func view(user types.UserSettings, filter Filter) (p Page) {
 
 	//get ff data - would be good not to get this on every activity call ********************
 	var ffData []types.Ff_data_point
-	if filter.ShowTss {
-		ffData = ff(user, filter)
+	var eventForecast types.Ff_data_point
+	var banisterModel banister.Model
+	var banisterCurve []PerformancePoint
+	var banisterTaper banister.Taper
+	if filter.ShowTss || filter.ShowBanister {
+		ffAll, forecastDays := ff(user, filter)
+		if filter.ShowTss {
+			ffData = ffAll
+			//OPTION (show reduced results) commenting out the follwing lines bypasses filter
+			if len(ffData) > (filter.Historylen + forecastDays) {
+				ffData = ffData[len(ffData)-(filter.Historylen+forecastDays) : len(ffData)]
+			}
+			for _, point := range ffData {
+				if !filter.EventDate.IsZero() && point.Date.Format(plan.DateFormat) == filter.EventDate.Format(plan.DateFormat) {
+					eventForecast = point
+				}
+			}
+		}
+		if filter.ShowBanister {
+			banisterModel, banisterCurve, banisterTaper = performance(ffAll, filter, filter.Historylen+forecastDays)
+		}
 	}
 
 	//power curve
@@ -1335,6 +1758,63 @@ func view(user types.UserSettings, filter Filter) (p Page) {
 		cpData, legends = powercurve(user, filter)
 	}
 
+	//durability (fatigued power curves)
+	var durabilityData []types.DurabilityCurve
+	if filter.ShowDurability {
+		durabilityData = durabilitycurves(user, filter)
+	}
+
+	//power curves over named date ranges
+	var cpCompare CurveComparison
+	if filter.ShowCompare {
+		cpCompare = comparecurves(user, filter)
+	}
+
+	//power profile
+	var profileData profile.Profile
+	var profileTrend []profile.Profile
+	if filter.ShowProfile {
+		profileData, profileTrend = profiles(user, filter)
+	}
+
+	//weight trend
+	var weightLog []weight.Entry
+	if filter.ShowWeight {
+		timeNow := time.Now().AddDate(0, 0, -filter.OffsetDays)
+		weightLog = weight.Log(user, timeNow.AddDate(0, 0, -filter.Historylen), timeNow)
+	}
+
+	//mean maximal heart rate and cadence
+	var mmData []Mm3
+	var mmLegend Cp3Legend
+	if filter.ShowMmHr {
+		mmData, mmLegend = meanmaxcurve(user, filter)
+	}
+
+	//power, heart rate and cadence distributions
+	var histogramData types.Histograms
+	if filter.ShowHist {
+		histogramData = histograms(user, filter)
+	}
+
+	//pedalling quadrants
+	var quadrantData quadrant.Summary
+	if filter.ShowQuad {
+		quadrantData = quadrants(user, filter)
+	}
+
+	//cadence and torque by power zone
+	var cadenceData []CadenceWeek
+	if filter.ShowCad {
+		cadenceData = cadencetrend(user, filter)
+	}
+
+	//climbs
+	var climbData []climb.Climb
+	if filter.ShowClimbs {
+		climbData = climbs(user, filter)
+	}
+
 	//Heart vs Power
 	var hvpData []Hvp
 	if filter.ShowHvp {
@@ -1363,18 +1843,7 @@ func view(user types.UserSettings, filter Filter) (p Page) {
 	} else {
 		hbzData, pbzData = hpbz(user, filter)
 	}
-	var zoneLabels types.ZoneLabels
-	zoneLabels.PowerZ1 = int(0.55 * float64(user.Ftp))
-	zoneLabels.PowerZ2 = int(0.74 * float64(user.Ftp))
-	zoneLabels.PowerZ3 = int(0.89 * float64(user.Ftp))
-	zoneLabels.PowerZ4 = int(1.04 * float64(user.Ftp))
-	zoneLabels.PowerZ5 = int(1.2 * float64(user.Ftp))
-	zoneLabels.HeartZ1 = int(0.81 * float64(user.Thr))
-	zoneLabels.HeartZ2 = int(0.89 * float64(user.Thr))
-	zoneLabels.HeartZ3 = int(0.93 * float64(user.Thr))
-	zoneLabels.HeartZ4 = int(0.99 * float64(user.Thr))
-	zoneLabels.HeartZ5a = int(1.02 * float64(user.Thr))
-	zoneLabels.HeartZ5b = int(1.06 * float64(user.Thr))
+	zoneLabels := zones.Labels(user)
 
 	//cp data - doesn't actually need to be reversed (corrected), but just wanted to for future flexibility
 	cpDataRev := make([]Cp3, 0)
@@ -1395,19 +1864,36 @@ func view(user types.UserSettings, filter Filter) (p Page) {
 
 	p = Page{
 		FfData:            ffData,
+		EventForecast:     eventForecast,
+		Banister:          banisterModel,
+		BanisterCurve:     banisterCurve,
+		BanisterTaper:     banisterTaper,
 		CpData:            cpDataRev,
+		CpCompare:         cpCompare,
 		HvpData:           hvpData,
 		TvdData:           tvdData,
 		TvdLegend:         tvdLegend,
 		CpLegend1:         legends.Series1,
 		CpLegend2:         legends.Series2,
 		CpLegend3:         legends.Series3,
+		MmData:            mmData,
+		MmLegend:          mmLegend,
 		HvpLabel:          hvp_label,
 		HbzData:           hbzData,
 		PbzData:           pbzData,
 		Settings:          user,
 		Filter:            filter,
 		ZoneLabels:        zoneLabels,
+		Histograms:        histogramData,
+		Quadrants:         quadrantData,
+		QuadrantLabels:    quadrant.Labels,
+		CadenceData:       cadenceData,
+		Climbs:            climbData,
+		Durability:        durabilityData,
+		DurabilityRows:    durability.Rows(durabilityData),
+		Profile:           profileData,
+		ProfileTrend:      profileTrend,
+		WeightLog:         weightLog,
 		StandardRidesHTML: selectHTML,
 	}
 	return
@@ -0,0 +1,190 @@
+/* Banister impulse-response (fitness-fatigue) model. Performance is modelled as p(t) = p0 + k1*fitness(t) - k2*fatigue(t), fitness and fatigue being the daily load decayed with time constants tau1 and tau2. The model is fitted to the rider's notable critical power performances, and then used to suggest how long a taper would peak them for a target date */
+package banister
+
+import (
+	"github.com/jezard/joulepersecond-go/utility"
+	"math"
+)
+
+//time constant ranges searched (days)
+const (
+	MinTau1, MaxTau1 = 10, 70
+	MinTau2, MaxTau2 = 2, 25
+)
+
+//performances needed before a fit is attempted
+const MinMarkers = 6
+
+//taper lengths tried (days), and the share of the usual daily load kept up while tapering
+const (
+	MaxTaper      = 21
+	TaperFraction = 0.4
+)
+
+//days of load averaged for the usual daily load before a taper
+const baselineDays = 28
+
+//a fitted model
+type Model struct {
+	P0, K1, K2 float64 //baseline performance and the fitness and fatigue gains
+	Tau1, Tau2 int     //fitness and fatigue time constants (days)
+	Markers    int     //performances the model was fitted to
+	Rmse       float64 //root mean square error of the fit
+	Fitted     bool
+}
+
+//a suggested taper for a target date
+type Taper struct {
+	Days        int     //days before the target to start tapering
+	Load        int     //daily load while tapering
+	Performance float64 //predicted on the target date
+	Untapered   float64 //predicted on the target date keeping up the usual load
+}
+
+//fitness and fatigue going into each day (loads up to the day before)
+func responses(loads []float64, tau1, tau2 int) (fitness, fatigue []float64) {
+	fitness = make([]float64, len(loads))
+	fatigue = make([]float64, len(loads))
+	d1, d2 := math.Exp(-1/float64(tau1)), math.Exp(-1/float64(tau2))
+	for t := 1; t < len(loads); t++ {
+		fitness[t] = (fitness[t-1] + loads[t-1]) * d1
+		fatigue[t] = (fatigue[t-1] + loads[t-1]) * d2
+	}
+	return
+}
+
+//fit the model to daily loads and performances (0 on days without one) - the time constants are searched, and the gains and baseline found by least squares for each pair
+func Fit(loads, performances []float64) (m Model) {
+	for _, p := range performances {
+		if p > 0 {
+			m.Markers++
+		}
+	}
+	if m.Markers < MinMarkers || len(loads) != len(performances) {
+		return
+	}
+	bestSse := math.MaxFloat64
+	for tau1 := MinTau1; tau1 <= MaxTau1; tau1++ {
+		for tau2 := MinTau2; tau2 <= MaxTau2 && tau2 < tau1; tau2++ {
+			fitness, fatigue := responses(loads, tau1, tau2)
+
+			//normal equations for p = p0 + k1*fitness - k2*fatigue
+			var a [3][3]float64
+			var b [3]float64
+			for t, p := range performances {
+				if p <= 0 {
+					continue
+				}
+				x := [3]float64{1, fitness[t], -fatigue[t]}
+				for i := range x {
+					for j := range x {
+						a[i][j] += x[i] * x[j]
+					}
+					b[i] += x[i] * p
+				}
+			}
+			coef, ok := solve(a, b)
+			if !ok || coef[1] <= 0 || coef[2] <= 0 {
+				continue
+			}
+			sse := 0.0
+			for t, p := range performances {
+				if p <= 0 {
+					continue
+				}
+				e := coef[0] + coef[1]*fitness[t] - coef[2]*fatigue[t] - p
+				sse += e * e
+			}
+			if sse < bestSse {
+				bestSse = sse
+				m.P0, m.K1, m.K2 = coef[0], coef[1], coef[2]
+				m.Tau1, m.Tau2 = tau1, tau2
+				m.Fitted = true
+			}
+		}
+	}
+	if m.Fitted {
+		m.Rmse = utility.Round(math.Sqrt(bestSse/float64(m.Markers)), .5, 1)
+	}
+	return
+}
+
+//solve a 3x3 linear system by Gaussian elimination
+func solve(a [3][3]float64, b [3]float64) (x [3]float64, ok bool) {
+	for col := 0; col < 3; col++ {
+		pivot := col
+		for row := col + 1; row < 3; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-9 {
+			return x, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for row := col + 1; row < 3; row++ {
+			f := a[row][col] / a[col][col]
+			for k := col; k < 3; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+			b[row] -= f * b[col]
+		}
+	}
+	for row := 2; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < 3; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+	return x, true
+}
+
+//predicted performance on each day from the daily loads
+func (m Model) Predict(loads []float64) []float64 {
+	predicted := make([]float64, len(loads))
+	if !m.Fitted {
+		return predicted
+	}
+	fitness, fatigue := responses(loads, m.Tau1, m.Tau2)
+	for t := range loads {
+		predicted[t] = utility.Round(m.P0+m.K1*fitness[t]-m.K2*fatigue[t], .5, 1)
+	}
+	return predicted
+}
+
+//the taper length that peaks the rider on the target day (an index into loads, after today) - loads up to and including today are the rider's own, after that the usual daily load is kept up until the taper starts
+func SuggestTaper(m Model, loads []float64, today, target int) (taper Taper) {
+	if !m.Fitted || today < 0 || today >= len(loads) || target <= today {
+		return
+	}
+	usual, n := 0.0, 0
+	for t := today; t >= 0 && t > today-baselineDays; t-- {
+		usual += loads[t]
+		n++
+	}
+	usual /= float64(n)
+	taper.Load = int(usual*TaperFraction + .5)
+
+	days := target - today
+	for length := 0; length <= MaxTaper && length <= days; length++ {
+		plan := make([]float64, target+1)
+		copy(plan, loads[:today+1])
+		for t := today + 1; t <= target; t++ {
+			plan[t] = usual
+			if t >= target-length {
+				plan[t] = usual * TaperFraction
+			}
+		}
+		p := m.Predict(plan)[target]
+		if length == 0 {
+			taper.Untapered = p
+		}
+		if length == 0 || p > taper.Performance {
+			taper.Days = length
+			taper.Performance = p
+		}
+	}
+	return
+}
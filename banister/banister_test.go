@@ -0,0 +1,64 @@
+package banister
+
+import (
+	"math"
+	"testing"
+)
+
+//loads varying from week to week, and the performances a known model gives every few days
+func modelled(m Model, days, every int) (loads, performances []float64) {
+	loads = make([]float64, days)
+	for t := range loads {
+		loads[t] = float64(40 + (t*37)%90)
+		if t%7 == 6 {
+			loads[t] = 0
+		}
+	}
+	fitness, fatigue := responses(loads, m.Tau1, m.Tau2)
+	performances = make([]float64, days)
+	for t := every; t < days; t += every {
+		performances[t] = m.P0 + m.K1*fitness[t] - m.K2*fatigue[t]
+	}
+	return
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name  string
+		model Model
+		every int
+	}{
+		{"typical", Model{P0: 250, K1: 0.1, K2: 0.2, Tau1: 42, Tau2: 7}, 5},
+		{"short fitness", Model{P0: 300, K1: 0.3, K2: 0.5, Tau1: 15, Tau2: 4}, 9},
+	}
+	for _, test := range tests {
+		loads, performances := modelled(test.model, 200, test.every)
+		m := Fit(loads, performances)
+		if !m.Fitted || m.Tau1 != test.model.Tau1 || m.Tau2 != test.model.Tau2 || math.Abs(m.K1-test.model.K1) > 1e-6 || math.Abs(m.K2-test.model.K2) > 1e-6 {
+			t.Errorf("%s: fitted %+v, want %+v", test.name, m, test.model)
+		}
+	}
+}
+
+func TestFitTooFewMarkers(t *testing.T) {
+	loads, performances := modelled(Model{P0: 250, K1: 0.1, K2: 0.2, Tau1: 42, Tau2: 7}, 30, 6)
+	if m := Fit(loads, performances); m.Fitted || m.Markers >= MinMarkers {
+		t.Errorf("fitted %+v from %d markers", m, m.Markers)
+	}
+}
+
+func TestSuggestTaper(t *testing.T) {
+	m := Model{P0: 250, K1: 0.1, K2: 0.2, Tau1: 42, Tau2: 7, Fitted: true}
+	loads, _ := modelled(m, 120, 5)
+	loads = append(loads, make([]float64, 30)...)
+	taper := SuggestTaper(m, loads, 119, 140)
+	if taper.Days == 0 || taper.Days > MaxTaper {
+		t.Errorf("taper of %d days, want 1-%d", taper.Days, MaxTaper)
+	}
+	if taper.Performance <= taper.Untapered {
+		t.Errorf("tapered performance %g no better than untapered %g", taper.Performance, taper.Untapered)
+	}
+	if none := SuggestTaper(m, loads, 119, 119); none.Days != 0 || none.Performance != 0 {
+		t.Errorf("taper for today: %+v", none)
+	}
+}
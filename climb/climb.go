@@ -0,0 +1,169 @@
+/* Climb detection. Finds the categorised climbs in a ride from its altitude and distance series and works out length, gain, gradient, VAM and power for each */
+package climb
+
+import (
+	"github.com/jezard/joulepersecond-go/utility"
+	"math"
+	"time"
+)
+
+//detection settings
+const (
+	smoothing       = 5    //seconds altitude is averaged over (gps altitude is noisy)
+	minLength       = 500  //metres
+	minGradient     = 3.0  //percent
+	minDescent      = 10.0 //metres a climb may drop below its high point before it's over...
+	maxDescent      = 30.0 //...up to this on long climbs (10% of the gain so far)
+	maxFlat         = 1000 //metres without a new high point before a climb is over
+	similarLength   = 0.1  //climbs within 10% of each other's length and gain are taken to be the same hill
+	similarGain     = 0.1
+	similarAltitude = 25.0 //and where they start within this many metres of altitude
+)
+
+//climb categories from the climb score (length in metres x average gradient in percent), lowest first
+var Categories = []struct {
+	Name  string
+	Score float64
+}{
+	{"Cat 4", 8000},
+	{"Cat 3", 16000},
+	{"Cat 2", 32000},
+	{"Cat 1", 64000},
+	{"HC", 80000},
+}
+
+//a climb within a ride
+type Climb struct {
+	Start, End    int    //sample indexes
+	StartTime     [3]int //in google chart timeofday format
+	Category      string
+	Length, Gain  float64 //metres
+	StartAltitude float64 //metres
+	Gradient      float64 //average, percent
+	Duration      int     //seconds
+	Vam           int     //metres climbed per hour
+	AvPower       int
+	Wkg           float64   //average watts per kilo (using the weight on the ride date)
+	ActivityId    string    //set for the user's climbs table
+	ActivityStart time.Time //"
+	Ascent        int       //climbs with the same ascent number are repeats of the same hill (see Group)
+}
+
+//find the categorised climbs in a ride - altitude and distance (cumulative) are in metres, one sample per second
+func Detect(altitude, distance []float64, powerSeries []int, weight float64) []Climb {
+	climbs := make([]Climb, 0)
+	samples := len(altitude)
+	if len(distance) < samples || samples < 2 || distance[samples-1] <= 0 {
+		return climbs
+	}
+	smoothed := smooth(altitude)
+
+	low, high := 0, 0
+	for i := 1; i < samples; i++ {
+		if smoothed[i] > smoothed[high] {
+			high = i
+		}
+		tolerance := math.Min(math.Max(minDescent, (smoothed[high]-smoothed[low])*0.1), maxDescent)
+		over := smoothed[high]-smoothed[i] > tolerance || distance[i]-distance[high] > maxFlat
+		if over || i == samples-1 {
+			if c, ok := measure(smoothed, distance, powerSeries, weight, low, high); ok {
+				climbs = append(climbs, c)
+			}
+			low, high = i, i
+		} else if smoothed[i] < smoothed[low] { //still going down to the foot of the climb
+			low, high = i, i
+		}
+	}
+	return climbs
+}
+
+//the climb from low to high, if it's long and steep enough to be categorised
+func measure(altitude, distance []float64, powerSeries []int, weight float64, low, high int) (c Climb, ok bool) {
+	c.Length = distance[high] - distance[low]
+	c.Gain = altitude[high] - altitude[low]
+	if c.Length < minLength || c.Gain <= 0 {
+		return
+	}
+	c.Gradient = c.Gain / c.Length * 100
+	if c.Gradient < minGradient {
+		return
+	}
+	c.Category = Category(c.Length, c.Gradient)
+	if c.Category == "" {
+		return
+	}
+	c.Start = low
+	c.End = high
+	c.StartTime = [3]int{low / 3600, (low / 60) % 60, low % 60}
+	c.StartAltitude = utility.Round(altitude[low], .5, 1)
+	c.Duration = high - low
+	c.Vam = int(c.Gain * 3600 / float64(c.Duration))
+	if high <= len(powerSeries) {
+		sum := 0
+		for _, val := range powerSeries[low:high] {
+			sum += val
+		}
+		c.AvPower = sum / c.Duration
+	}
+	if weight > 0 {
+		c.Wkg = utility.Round(float64(c.AvPower)/weight, .5, 2)
+	}
+	c.Length = utility.Round(c.Length, .5, 0)
+	c.Gain = utility.Round(c.Gain, .5, 0)
+	c.Gradient = utility.Round(c.Gradient, .5, 1)
+	return c, true
+}
+
+//a climb's category, empty where it's too small to be categorised
+func Category(length, gradient float64) (category string) {
+	score := length * gradient
+	for _, cat := range Categories {
+		if score >= cat.Score {
+			category = cat.Name
+		}
+	}
+	return
+}
+
+//number the ascents so that repeats of the same hill (similar length, gain and starting altitude) share a number - climbs should be in date order
+func Group(climbs []Climb) []Climb {
+	ascents := 0
+	for i := range climbs {
+		climbs[i].Ascent = 0
+		for j := 0; j < i; j++ {
+			if similar(climbs[i], climbs[j]) {
+				climbs[i].Ascent = climbs[j].Ascent
+				break
+			}
+		}
+		if climbs[i].Ascent == 0 {
+			ascents++
+			climbs[i].Ascent = ascents
+		}
+	}
+	return climbs
+}
+
+func similar(a, b Climb) bool {
+	return math.Abs(a.Length-b.Length) <= similarLength*b.Length &&
+		math.Abs(a.Gain-b.Gain) <= similarGain*b.Gain &&
+		math.Abs(a.StartAltitude-b.StartAltitude) <= similarAltitude
+}
+
+//rolling average of the altitude
+func smooth(altitude []float64) []float64 {
+	smoothed := make([]float64, len(altitude))
+	var sum float64
+	for i, val := range altitude {
+		sum += val
+		if i >= smoothing {
+			sum -= altitude[i-smoothing]
+		}
+		count := i + 1
+		if count > smoothing {
+			count = smoothing
+		}
+		smoothed[i] = sum / float64(count)
+	}
+	return smoothed
+}
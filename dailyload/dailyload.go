@@ -0,0 +1,320 @@
+/* Daily training load. Each user's load, session RPE load and fitness/freshness for every day from their first activity (or seed date) are kept in the user_daily_load table. The table is brought up to date from an activity's day whenever one is processed, edited or deleted, so the fitness/freshness charts are read with a single range query rather than worked out from every activity on each page load */
+package dailyload
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/jezard/joulepersecond-go/conf"
+	"github.com/jezard/joulepersecond-go/loadmetric"
+	"github.com/jezard/joulepersecond-go/types"
+	"github.com/jezard/joulepersecond-go/utility"
+	"log"
+	"math"
+	"time"
+)
+
+//date format for keys
+const DateFormat = "2006-01-02"
+
+var config = conf.Configuration()
+
+//a stored day
+type Day struct {
+	types.Ff_data_point
+	Cps   []types.CPMs //critical powers of each of the day's activities in order, for picking out notable CPs
+	Basis string       //settings the day was worked out with (see Basis)
+}
+
+//the settings the stored values depend on - days worked out with other settings are rebuilt
+func Basis(user types.UserSettings) string {
+	seedDate := ""
+	if !user.SeedDate.IsZero() {
+		seedDate = user.SeedDate.Format(DateFormat)
+	}
+	return fmt.Sprintf("%s/%d/%d/%g/%g/%s/%t/%s", user.LoadMetric, user.Atl_constant, user.Ctl_constant, user.AtlSeed, user.CtlSeed, seedDate, user.UseEstimated, user.Location)
+}
+
+//the day (midnight in the time zone) a time falls on
+func DayOf(t time.Time, loc *time.Location) time.Time {
+	year, month, day := t.In(loc).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+//work out a day's fitness and freshness from the day before's and its own load
+func Step(yesterday types.Ff_data_point, today *types.Ff_data_point, user types.UserSettings) {
+	today.Atl = yesterday.Atl + (float64(today.Tss)-yesterday.Atl)/float64(user.Atl_constant)
+	today.Ctl = yesterday.Ctl + (float64(today.Tss)-yesterday.Ctl)/float64(user.Ctl_constant)
+	today.Tsb = today.Ctl - today.Atl
+
+	//and the same again from how the sessions felt
+	today.RpeAtl = yesterday.RpeAtl + (float64(today.RpeLoad)-yesterday.RpeAtl)/float64(user.Atl_constant)
+	today.RpeCtl = yesterday.RpeCtl + (float64(today.RpeLoad)-yesterday.RpeCtl)/float64(user.Ctl_constant)
+	today.RpeTsb = today.RpeCtl - today.RpeAtl
+}
+
+//days in a training week and in the acute and chronic windows of the workload ratio
+const (
+	WeekDays    = 7
+	AcuteDays   = 7
+	ChronicDays = 28
+)
+
+//monotony is capped where the week's load barely changes from day to day
+const MaxMonotony = 10.0
+
+//default warning thresholds for the overload indicators
+const (
+	RampWarning     = 8.0    //CTL gained in a week
+	MonotonyWarning = 2.0    //Foster's suggested upper limit
+	StrainWarning   = 1500.0 //weekly load x monotony
+	AcwrWarning     = 1.5    //acute:chronic workload ratio
+)
+
+//add the overload indicators to a daily fitness/freshness series - the weekly CTL ramp rate, Foster's monotony
+//(mean daily load over its standard deviation for the week) and strain (weekly load x monotony), and the
+//acute:chronic workload ratio of the rolling average loads. Each is left at 0 until there are enough days for it
+func Trends(series []types.Ff_data_point) {
+	for t := range series {
+		if t >= WeekDays {
+			series[t].RampRate = utility.Round(series[t].Ctl-series[t-WeekDays].Ctl, .5, 1)
+		}
+		if t >= WeekDays-1 {
+			week := 0.0
+			for _, point := range series[t-WeekDays+1 : t+1] {
+				week += float64(point.Tss)
+			}
+			mean := week / WeekDays
+			variance := 0.0
+			for _, point := range series[t-WeekDays+1 : t+1] {
+				variance += (float64(point.Tss) - mean) * (float64(point.Tss) - mean)
+			}
+			sd := math.Sqrt(variance / WeekDays)
+			monotony := 0.0
+			if mean > 0 {
+				monotony = MaxMonotony
+				if sd > 0 && mean/sd < MaxMonotony {
+					monotony = mean / sd
+				}
+			}
+			series[t].Monotony = utility.Round(monotony, .5, 2)
+			series[t].Strain = int(week*monotony + .5)
+		}
+		if t >= ChronicDays-1 {
+			acute, chronic := 0.0, 0.0
+			for i, point := range series[t-ChronicDays+1 : t+1] {
+				chronic += float64(point.Tss)
+				if i >= ChronicDays-AcuteDays {
+					acute += float64(point.Tss)
+				}
+			}
+			if chronic > 0 {
+				series[t].Acwr = utility.Round((acute/AcuteDays)/(chronic/ChronicDays), .5, 2)
+			}
+		}
+	}
+}
+
+//the value going into the first day - the user's seeds
+func seed(user types.UserSettings) types.Ff_data_point {
+	return types.Ff_data_point{Atl: user.AtlSeed, Ctl: user.CtlSeed}
+}
+
+//the user's stored days in date order, rebuilding the table first where it was worked out with other settings (an
+//update only carries on from a day with the same settings, so the last day has the latest)
+func Get(user types.UserSettings) []Day {
+	days := get(user)
+	if len(days) == 0 || days[len(days)-1].Basis != Basis(user) {
+		Update(user, time.Time{})
+		days = get(user)
+	}
+	return days
+}
+
+//the user's fitness and freshness today, carried on from the last stored day
+func Current(user types.UserSettings) types.Ff_data_point {
+	days := Get(user)
+	if len(days) == 0 {
+		return types.Ff_data_point{}
+	}
+	today := DayOf(time.Now(), user.Location)
+	current := days[len(days)-1].Ff_data_point
+	for date := current.Date.AddDate(0, 0, 1); !date.After(today); date = date.AddDate(0, 0, 1) {
+		next := types.Ff_data_point{Date: date}
+		Step(current, &next, user)
+		current = next
+	}
+	return current
+}
+
+func get(user types.UserSettings) []Day {
+	days := make([]Day, 0)
+
+	var d Day
+	var cp_json []byte
+
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	iter := session.Query(`SELECT load_date, tss, rpe_load, ctl, atl, tsb, rpe_ctl, rpe_atl, rpe_tsb, cp_json, motivation_level, perceived_effort, basis FROM joulepersecond.user_daily_load WHERE user_id = ? ORDER BY load_date ASC`, user.Id).Iter()
+	for iter.Scan(&d.Date, &d.Tss, &d.RpeLoad, &d.Ctl, &d.Atl, &d.Tsb, &d.RpeCtl, &d.RpeAtl, &d.RpeTsb, &cp_json, &d.Meta.MotivationLevel, &d.Meta.PerceivedEffort, &d.Basis) {
+		d.Date = d.Date.In(user.Location)
+		d.Cps = nil
+		json.Unmarshal(cp_json, &d.Cps)
+		days = append(days, d)
+	}
+	if err := iter.Close(); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	return days
+}
+
+//bring the table up to date from the day of a change (an activity processed, edited or deleted) to today - the
+//day before carries the fitness and freshness forward, so only the days from the change are worked out again. The
+//whole table is rebuilt where there's no day before to carry on from, or it was worked out with other settings
+func Update(user types.UserSettings, from time.Time) {
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	basis := Basis(user)
+	start := DayOf(user.SeedDate, user.Location)
+	if user.SeedDate.IsZero() {
+		start = time.Time{}
+	}
+	from = DayOf(from, user.Location)
+	if from.Before(start) {
+		from = start
+	}
+
+	//carry on from the last stored day before the change (filling any days since with no load), otherwise start again from the seeds
+	var prev *Day
+	if from.After(start) {
+		var d Day
+		if err := session.Query(`SELECT load_date, tss, rpe_load, ctl, atl, tsb, rpe_ctl, rpe_atl, rpe_tsb, basis FROM joulepersecond.user_daily_load WHERE user_id = ? AND load_date < ? ORDER BY load_date DESC LIMIT 1`, user.Id, from).Scan(&d.Date, &d.Tss, &d.RpeLoad, &d.Ctl, &d.Atl, &d.Tsb, &d.RpeCtl, &d.RpeAtl, &d.RpeTsb, &d.Basis); err == nil {
+			d.Date = DayOf(d.Date, user.Location)
+			prev = &d
+		}
+	}
+	from, rebuild := resume(from, start, prev, basis)
+	yesterday := seed(user)
+	if !rebuild {
+		yesterday = prev.Ff_data_point
+	}
+
+	//the days' loads from the activities on or after the change (a day early to catch activities stamped in another time zone)
+	loads := make(map[time.Time]*Day)
+	first := time.Time{}
+
+	var user_data types.Metrics
+	var end_summary_json []byte
+	var has_power, has_heart bool
+	var activity_start time.Time
+	var activity_id string
+
+	since := from.AddDate(0, 0, -1)
+	if from.IsZero() {
+		since = time.Unix(0, 0)
+	}
+	iter := session.Query(`SELECT activity_start, activity_id, end_summary_json, has_power, has_heart FROM joulepersecond.user_activity WHERE user_id = ? AND activity_start >= ? ORDER BY activity_start ASC`, user.Id, since).Iter()
+	for iter.Scan(&activity_start, &activity_id, &end_summary_json, &has_power, &has_heart) {
+		user_data = types.Metrics{} //clear the last activity's values (the load map would otherwise be merged)
+		json.Unmarshal(end_summary_json, &user_data)
+		date := DayOf(activity_start, user.Location)
+		if !user_data.StartTime.IsZero() {
+			date = DayOf(user_data.StartTime, user.Location)
+		}
+		if date.Before(from) {
+			continue
+		}
+		if first.IsZero() {
+			first = date
+		}
+
+		//power estimated from speed only counts where the user has chosen to count it (loadmetric.Value leaves out its power based load)
+		var user_cpms types.CPMs
+		if !user_data.EstimatedPower || user.UseEstimated {
+			var cp_data_json []byte
+			if err := session.Query(`SELECT cp_data_json FROM joulepersecond.proc_activity WHERE activity_id = ? LIMIT 1`, activity_id).Scan(&cp_data_json); err != nil {
+				log.Printf("Location:%v", err)
+			} else {
+				json.Unmarshal(cp_data_json, &user_cpms)
+			}
+		}
+
+		//values for each scanned activity
+		var user_tss, perc_effort, mot_level, session_rpe int
+		session.Query(`SELECT tss_value, motivation_level, perceived_effort, session_rpe FROM activity_meta WHERE activity_id = ?`, activity_id).Scan(&user_tss, &mot_level, &perc_effort, &session_rpe)
+
+		d, ok := loads[date]
+		if !ok {
+			d = &Day{}
+			loads[date] = d
+		}
+		rpeLoad := loadmetric.SessionRpeLoad(session_rpe, user_data.Dur)
+		d.RpeLoad += rpeLoad
+
+		//get a value for tss whether user set, from the user's chosen load metric or, for rides with neither power nor heart rate, session RPE
+		if user_tss > 0 {
+			d.Tss += user_tss
+		} else if has_power || has_heart {
+			d.Tss += loadmetric.Value(user_data, user)
+		} else {
+			d.Tss += rpeLoad
+		}
+		d.Cps = append(d.Cps, user_cpms)
+		d.Meta.MotivationLevel = mot_level
+		d.Meta.PerceivedEffort = perc_effort
+	}
+	if err := iter.Close(); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+
+	//a rebuild with no seed date starts at the first activity
+	if from.IsZero() {
+		from = first
+	}
+
+	//clear out the days being worked out again (including any that no longer have activities) - all of them on a rebuild
+	if rebuild {
+		if err := session.Query(`DELETE FROM user_daily_load WHERE user_id = ?`, user.Id).Exec(); err != nil {
+			log.Printf("Location:%v", err)
+		}
+	} else if err := session.Query(`DELETE FROM user_daily_load WHERE user_id = ? AND load_date >= ?`, user.Id, from).Exec(); err != nil {
+		log.Printf("Location:%v", err)
+	}
+	if from.IsZero() { //no activities
+		return
+	}
+
+	today := DayOf(time.Now(), user.Location)
+	for date := from; !date.After(today); date = date.AddDate(0, 0, 1) {
+		d := Day{}
+		if load, ok := loads[date]; ok {
+			d = *load
+		}
+		d.Date = date
+		Step(yesterday, &d.Ff_data_point, user)
+		cp_json, _ := json.Marshal(d.Cps)
+		if err := session.Query(`INSERT INTO user_daily_load (user_id, load_date, tss, rpe_load, ctl, atl, tsb, rpe_ctl, rpe_atl, rpe_tsb, cp_json, motivation_level, perceived_effort, basis) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			user.Id, d.Date, d.Tss, d.RpeLoad, d.Ctl, d.Atl, d.Tsb, d.RpeCtl, d.RpeAtl, d.RpeTsb, cp_json, d.Meta.MotivationLevel, d.Meta.PerceivedEffort, basis).Exec(); err != nil {
+			log.Printf("Location:%v", err)
+		}
+		yesterday = d.Ff_data_point
+	}
+}
+
+//where an update starts given the day of the change (no earlier than start, the seed date's day or zero) and the last
+//stored day before it (nil where there isn't one) - the day after that one where it was worked out with the same
+//settings, otherwise a rebuild from start
+func resume(from, start time.Time, prev *Day, basis string) (time.Time, bool) {
+	if !from.After(start) || prev == nil || prev.Basis != basis {
+		return start, true
+	}
+	return prev.Date.AddDate(0, 0, 1), false
+}
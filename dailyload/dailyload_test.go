@@ -0,0 +1,99 @@
+package dailyload
+
+import (
+	"github.com/jezard/joulepersecond-go/types"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestResume(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2026, time.March, d, 0, 0, 0, 0, time.UTC)
+	}
+	stored := func(d int, basis string) *Day {
+		prev := Day{Basis: basis}
+		prev.Date = day(d)
+		return &prev
+	}
+	tests := []struct {
+		name        string
+		from, start time.Time
+		prev        *Day
+		wantFrom    time.Time
+		wantRebuild bool
+	}{
+		{"full rebuild without a seed date", time.Time{}, time.Time{}, nil, time.Time{}, true},
+		{"change on the seed date", day(1), day(1), stored(1, "a"), day(1), true},
+		{"nothing stored before the change", day(10), time.Time{}, nil, time.Time{}, true},
+		{"nothing stored after the seed date", day(10), day(1), nil, day(1), true},
+		{"stored with other settings", day(10), day(1), stored(9, "b"), day(1), true},
+		{"carries on from the day before", day(10), day(1), stored(9, "a"), day(10), false},
+		{"fills the days since the last stored day", day(10), time.Time{}, stored(6, "a"), day(7), false},
+	}
+	for _, test := range tests {
+		from, rebuild := resume(test.from, test.start, test.prev, "a")
+		if !from.Equal(test.wantFrom) || rebuild != test.wantRebuild {
+			t.Errorf("%s: resume = %v, %t, want %v, %t", test.name, from, rebuild, test.wantFrom, test.wantRebuild)
+		}
+	}
+}
+
+func TestDayOf(t *testing.T) {
+	late := time.Date(2025, time.December, 31, 23, 30, 0, 0, time.UTC)
+	auckland := time.FixedZone("NZDT", 13*60*60)
+	tests := []struct {
+		loc  *time.Location
+		want time.Time
+	}{
+		{time.UTC, time.Date(2025, time.December, 31, 0, 0, 0, 0, time.UTC)},
+		{auckland, time.Date(2026, time.January, 1, 0, 0, 0, 0, auckland)},
+	}
+	for _, test := range tests {
+		if got := DayOf(late, test.loc); !got.Equal(test.want) {
+			t.Errorf("DayOf in %v = %v, want %v", test.loc, got, test.want)
+		}
+	}
+}
+
+func TestStep(t *testing.T) {
+	user := types.UserSettings{Atl_constant: 7, Ctl_constant: 42}
+	tests := []struct {
+		yesterday                    types.Ff_data_point
+		tss, rpeLoad                 int
+		wantAtl, wantCtl, wantRpeAtl float64
+	}{
+		{types.Ff_data_point{}, 70, 0, 10, 70.0 / 42, 0},
+		{types.Ff_data_point{Atl: 70, Ctl: 42}, 0, 350, 60, 41, 50},
+		{types.Ff_data_point{Atl: 50, Ctl: 50}, 50, 0, 50, 50, 0},
+	}
+	for _, test := range tests {
+		today := types.Ff_data_point{Tss: test.tss, RpeLoad: test.rpeLoad}
+		Step(test.yesterday, &today, user)
+		if math.Abs(today.Atl-test.wantAtl) > 1e-9 || math.Abs(today.Ctl-test.wantCtl) > 1e-9 || math.Abs(today.RpeAtl-test.wantRpeAtl) > 1e-9 {
+			t.Errorf("Step(%+v, tss %d) = atl %g ctl %g rpe atl %g, want %g %g %g", test.yesterday, test.tss, today.Atl, today.Ctl, today.RpeAtl, test.wantAtl, test.wantCtl, test.wantRpeAtl)
+		}
+		if today.Tsb != today.Ctl-today.Atl {
+			t.Errorf("Step(%+v, tss %d): tsb %g isn't ctl - atl", test.yesterday, test.tss, today.Tsb)
+		}
+	}
+}
+
+func TestBasis(t *testing.T) {
+	user := types.UserSettings{LoadMetric: "tss", Atl_constant: 7, Ctl_constant: 42, Location: time.UTC}
+	changed := []func(u *types.UserSettings){
+		func(u *types.UserSettings) { u.LoadMetric = "hrtss" },
+		func(u *types.UserSettings) { u.Ctl_constant = 28 },
+		func(u *types.UserSettings) { u.CtlSeed = 40 },
+		func(u *types.UserSettings) { u.SeedDate = time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC) },
+		func(u *types.UserSettings) { u.UseEstimated = true },
+		func(u *types.UserSettings) { u.Location = time.FixedZone("NZDT", 13*60*60) },
+	}
+	for i, change := range changed {
+		other := user
+		change(&other)
+		if Basis(other) == Basis(user) {
+			t.Errorf("change %d: basis %q unchanged", i, Basis(other))
+		}
+	}
+}
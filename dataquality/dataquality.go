@@ -0,0 +1,248 @@
+/* Data quality - cleans the 1 second power, heart rate and cadence series before any metrics are calculated, recording each correction made and scoring the activity's data quality */
+package dataquality
+
+import (
+	"github.com/jezard/joulepersecond-go/types"
+	"sort"
+	"time"
+)
+
+//rule names
+const (
+	PowerSpike    = "power_spike"    //power well beyond the ride's own high percentile
+	HrDropout     = "hr_dropout"     //heart rate strap lost contact (zero readings)
+	HrStuck       = "hr_stuck"       //heart rate unchanged for too long
+	CadenceLocked = "cadence_locked" //cadence reported while no power is being produced
+)
+
+//rule names for display
+var Labels = map[string]string{
+	PowerSpike:    "Power spike",
+	HrDropout:     "Heart rate dropout",
+	HrStuck:       "Heart rate unchanged",
+	CadenceLocked: "Cadence without power",
+}
+
+//a single correction (or flag) made to a range of samples
+type Correction struct {
+	Rule       string
+	Series     string //power, heart or cadence
+	Start, End int    //sample (second) offsets, end exclusive
+	StartTime  [3]int //in google chart timeofday format
+	EndTime    [3]int
+	Corrected  bool //false where the range is flagged but left unchanged
+}
+
+//all of the corrections for an activity and its quality score
+type Report struct {
+	Corrections []Correction
+	Score       int //0-100, the percentage of samples left untouched and unflagged
+}
+
+//default rules, used for any setting the user hasn't changed (cleaning is on unless they've turned it off)
+var Defaults = types.CleaningRules{
+	Enabled:            true,
+	SpikePercentile:    99,
+	SpikeFactor:        2.5,
+	HrDropoutSeconds:   60,
+	HrStuckSeconds:     120,
+	CadenceLockSeconds: 10,
+}
+
+//fill any unset rule values with the defaults
+func WithDefaults(rules types.CleaningRules) types.CleaningRules {
+	if rules.SpikePercentile == 0 {
+		rules.SpikePercentile = Defaults.SpikePercentile
+	}
+	if rules.SpikeFactor == 0 {
+		rules.SpikeFactor = Defaults.SpikeFactor
+	}
+	if rules.HrDropoutSeconds == 0 {
+		rules.HrDropoutSeconds = Defaults.HrDropoutSeconds
+	}
+	if rules.HrStuckSeconds == 0 {
+		rules.HrStuckSeconds = Defaults.HrStuckSeconds
+	}
+	if rules.CadenceLockSeconds == 0 {
+		rules.CadenceLockSeconds = Defaults.CadenceLockSeconds
+	}
+	return rules
+}
+
+//clean the series in place (they must be of equal length) and report what was done
+func Clean(powerSeries, heartSeries, cadenceSeries []int, rules types.CleaningRules) (report Report) {
+	report.Corrections = make([]Correction, 0)
+	report.Score = 100
+	samples := len(powerSeries)
+	if !rules.Enabled || samples == 0 || len(heartSeries) != samples || len(cadenceSeries) != samples {
+		return
+	}
+	rules = WithDefaults(rules)
+
+	report.Corrections = append(report.Corrections, powerSpikes(powerSeries, rules)...)
+	report.Corrections = append(report.Corrections, hrDropouts(heartSeries, rules)...)
+	report.Corrections = append(report.Corrections, hrStuck(heartSeries, rules)...)
+	report.Corrections = append(report.Corrections, cadenceLocked(cadenceSeries, powerSeries, rules)...)
+
+	//score on the number of seconds affected by one or more corrections
+	affected := make([]bool, samples)
+	affectedCount := 0
+	for i, correction := range report.Corrections {
+		for j := correction.Start; j < correction.End; j++ {
+			if !affected[j] {
+				affected[j] = true
+				affectedCount++
+			}
+		}
+		report.Corrections[i].StartTime = timeOfDay(correction.Start)
+		report.Corrections[i].EndTime = timeOfDay(correction.End)
+	}
+	report.Score = 100 - (affectedCount*100)/samples
+	return
+}
+
+//seconds to google chart timeofday format
+func timeOfDay(seconds int) (t [3]int) {
+	elapsed := time.Duration(seconds) * time.Second
+	t[0] = int(elapsed.Hours())
+	t[1] = int(elapsed.Minutes()) % 60
+	t[2] = int(elapsed.Seconds()) % 60
+	return
+}
+
+//value at a percentile of the non zero values in a series
+func percentile(series []int, p float64) int {
+	values := make([]int, 0)
+	for _, val := range series {
+		if val > 0 {
+			values = append(values, val)
+		}
+	}
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Ints(values)
+	return values[int(p/100*float64(len(values)-1))]
+}
+
+//replace series[start:end] with a straight line between the neighbouring good values
+func interpolate(series []int, start, end int) {
+	before := -1
+	after := -1
+	if start > 0 {
+		before = series[start-1]
+	}
+	if end < len(series) {
+		after = series[end]
+	}
+	if before < 0 {
+		before = after
+	}
+	if after < 0 {
+		after = before
+	}
+	if before < 0 {
+		before, after = 0, 0
+	}
+	gap := end - start + 1
+	for i := start; i < end; i++ {
+		series[i] = before + (after-before)*(i-start+1)/gap
+	}
+}
+
+//power spikes - anything beyond SpikeFactor x the SpikePercentile power is replaced with the surrounding power
+func powerSpikes(powerSeries []int, rules types.CleaningRules) []Correction {
+	corrections := make([]Correction, 0)
+	limit := int(float64(percentile(powerSeries, rules.SpikePercentile)) * rules.SpikeFactor)
+	if limit == 0 {
+		return corrections
+	}
+	for i := 0; i < len(powerSeries); i++ {
+		if powerSeries[i] <= limit {
+			continue
+		}
+		start := i
+		for i < len(powerSeries) && powerSeries[i] > limit {
+			i++
+		}
+		interpolate(powerSeries, start, i)
+		corrections = append(corrections, Correction{Rule: PowerSpike, Series: "power", Start: start, End: i, Corrected: true})
+	}
+	return corrections
+}
+
+//heart rate dropouts - zero readings part way through a ride with heart rate. Short gaps are filled, longer ones flagged
+func hrDropouts(heartSeries []int, rules types.CleaningRules) []Correction {
+	corrections := make([]Correction, 0)
+	first, last := -1, -1
+	for i, val := range heartSeries {
+		if val > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		//no heart rate recorded at all - nothing to correct
+		return corrections
+	}
+	for i := first; i <= last; i++ {
+		if heartSeries[i] > 0 {
+			continue
+		}
+		start := i
+		for i <= last && heartSeries[i] == 0 {
+			i++
+		}
+		correction := Correction{Rule: HrDropout, Series: "heart", Start: start, End: i}
+		if i-start <= rules.HrDropoutSeconds {
+			interpolate(heartSeries, start, i)
+			correction.Corrected = true
+		}
+		corrections = append(corrections, correction)
+	}
+	return corrections
+}
+
+//heart rate stuck on a single value (strap or receiver fault) - flagged only as the true values are unknown
+func hrStuck(heartSeries []int, rules types.CleaningRules) []Correction {
+	corrections := make([]Correction, 0)
+	for i := 0; i < len(heartSeries); {
+		start := i
+		for i < len(heartSeries) && heartSeries[i] == heartSeries[start] {
+			i++
+		}
+		if heartSeries[start] > 0 && i-start >= rules.HrStuckSeconds {
+			corrections = append(corrections, Correction{Rule: HrStuck, Series: "heart", Start: start, End: i})
+		}
+	}
+	return corrections
+}
+
+//cadence held at a constant value while no power is produced (freewheeling with a stuck sensor) is set to zero
+func cadenceLocked(cadenceSeries, powerSeries []int, rules types.CleaningRules) []Correction {
+	corrections := make([]Correction, 0)
+	if percentile(powerSeries, 50) == 0 {
+		//no power meter so no way of telling
+		return corrections
+	}
+	for i := 0; i < len(cadenceSeries); {
+		start := i
+		for i < len(cadenceSeries) && cadenceSeries[i] == cadenceSeries[start] && powerSeries[i] == 0 {
+			i++
+		}
+		if i == start {
+			//power being produced - move on
+			i++
+			continue
+		}
+		if cadenceSeries[start] > 0 && i-start >= rules.CadenceLockSeconds {
+			for j := start; j < i; j++ {
+				cadenceSeries[j] = 0
+			}
+			corrections = append(corrections, Correction{Rule: CadenceLocked, Series: "cadence", Start: start, End: i, Corrected: true})
+		}
+	}
+	return corrections
+}
@@ -0,0 +1,155 @@
+package dataquality
+
+import (
+	"github.com/jezard/joulepersecond-go/types"
+	"reflect"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	tests := []struct {
+		name       string
+		series     []int
+		start, end int
+		want       []int
+	}{
+		{"between good values", []int{10, 0, 0, 40}, 1, 3, []int{10, 20, 30, 40}},
+		{"at the start", []int{0, 0, 30}, 0, 2, []int{30, 30, 30}},
+		{"at the end", []int{20, 0, 0}, 1, 3, []int{20, 20, 20}},
+		{"the whole series", []int{5, 5}, 0, 2, []int{0, 0}},
+	}
+	for _, test := range tests {
+		interpolate(test.series, test.start, test.end)
+		if !reflect.DeepEqual(test.series, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, test.series, test.want)
+		}
+	}
+}
+
+//a series of n values of val
+func steady(n, val int) []int {
+	series := make([]int, n)
+	for i := range series {
+		series[i] = val
+	}
+	return series
+}
+
+func TestPowerSpikes(t *testing.T) {
+	spiked := steady(100, 200)
+	spiked[50] = 2000
+	tests := []struct {
+		name   string
+		series []int
+		factor float64
+		want   []Correction
+		after  int //power at the spike afterwards
+	}{
+		{"spike replaced", spiked, 2.5, []Correction{{Rule: PowerSpike, Series: "power", Start: 50, End: 51, Corrected: true}}, 200},
+		{"within the factor", spiked, 20, []Correction{}, 2000},
+		{"no power", steady(100, 0), 2.5, []Correction{}, 0},
+	}
+	for _, test := range tests {
+		series := append([]int(nil), test.series...)
+		got := powerSpikes(series, types.CleaningRules{SpikePercentile: 99, SpikeFactor: test.factor})
+		if !reflect.DeepEqual(got, test.want) || series[50] != test.after {
+			t.Errorf("%s: got %+v (power %d), want %+v (power %d)", test.name, got, series[50], test.want, test.after)
+		}
+	}
+}
+
+func TestHrDropouts(t *testing.T) {
+	tests := []struct {
+		name   string
+		series []int
+		want   []Correction
+		after  []int
+	}{
+		{
+			"short gap filled, longer gap flagged, either end left alone",
+			[]int{0, 120, 0, 0, 0, 130, 0, 0, 0, 0, 140, 0},
+			[]Correction{{Rule: HrDropout, Series: "heart", Start: 2, End: 5, Corrected: true}, {Rule: HrDropout, Series: "heart", Start: 6, End: 10}},
+			[]int{0, 120, 122, 125, 127, 130, 0, 0, 0, 0, 140, 0},
+		},
+		{"no heart rate", []int{0, 0, 0}, []Correction{}, []int{0, 0, 0}},
+	}
+	for _, test := range tests {
+		got := hrDropouts(test.series, types.CleaningRules{HrDropoutSeconds: 3})
+		if !reflect.DeepEqual(got, test.want) || !reflect.DeepEqual(test.series, test.after) {
+			t.Errorf("%s: got %+v %v, want %+v %v", test.name, got, test.series, test.want, test.after)
+		}
+	}
+}
+
+func TestHrStuck(t *testing.T) {
+	tests := []struct {
+		name   string
+		series []int
+		want   []Correction
+	}{
+		{"stuck run flagged", []int{100, 100, 100, 101, 102, 102}, []Correction{{Rule: HrStuck, Series: "heart", Start: 0, End: 3}}},
+		{"zeros aren't stuck", []int{0, 0, 0, 0, 101}, []Correction{}},
+		{"short runs", []int{100, 100, 101, 101}, []Correction{}},
+	}
+	for _, test := range tests {
+		if got := hrStuck(test.series, types.CleaningRules{HrStuckSeconds: 3}); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCadenceLocked(t *testing.T) {
+	tests := []struct {
+		name           string
+		cadence, power []int
+		want           []Correction
+		after          []int
+	}{
+		{
+			"locked while coasting",
+			[]int{90, 90, 85, 85, 85, 85, 90, 90, 80, 90},
+			[]int{200, 200, 0, 0, 0, 0, 200, 200, 0, 200},
+			[]Correction{{Rule: CadenceLocked, Series: "cadence", Start: 2, End: 6, Corrected: true}},
+			[]int{90, 90, 0, 0, 0, 0, 90, 90, 80, 90},
+		},
+		{
+			"no power meter",
+			[]int{85, 85, 85, 85, 85},
+			[]int{0, 0, 0, 0, 0},
+			[]Correction{},
+			[]int{85, 85, 85, 85, 85},
+		},
+	}
+	for _, test := range tests {
+		got := cadenceLocked(test.cadence, test.power, types.CleaningRules{CadenceLockSeconds: 3})
+		if !reflect.DeepEqual(got, test.want) || !reflect.DeepEqual(test.cadence, test.after) {
+			t.Errorf("%s: got %+v %v, want %+v %v", test.name, got, test.cadence, test.want, test.after)
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	spiked := steady(100, 200)
+	spiked[50] = 2000
+	rules := types.CleaningRules{Enabled: true, SpikePercentile: 99, SpikeFactor: 2.5, HrDropoutSeconds: 3, HrStuckSeconds: 3, CadenceLockSeconds: 3}
+	disabled := rules
+	disabled.Enabled = false
+	tests := []struct {
+		name                  string
+		power, heart, cadence []int
+		rules                 types.CleaningRules
+		corrections, score    int
+	}{
+		{"one second in a hundred", spiked, steady(100, 0), steady(100, 90), rules, 1, 99},
+		{"every second flagged", steady(10, 0), steady(10, 120), steady(10, 0), rules, 1, 0},
+		{"turned off", spiked, steady(100, 0), steady(100, 90), disabled, 0, 100},
+		{"series of different lengths", spiked, steady(99, 0), steady(100, 90), rules, 0, 100},
+	}
+	for _, test := range tests {
+		power := append([]int(nil), test.power...)
+		report := Clean(power, test.heart, test.cadence, test.rules)
+		if len(report.Corrections) != test.corrections || report.Score != test.score {
+			t.Errorf("%s: %d corrections scoring %d, want %d scoring %d", test.name, len(report.Corrections), report.Score, test.corrections, test.score)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/* Durability (fatigue resistance). Mean maximal power from only the part of a ride after a given amount of work, so a rider's "fatigued" power curve can be set against their fresh one */
+package durability
+
+import (
+	"github.com/jezard/joulepersecond-go/types"
+	"github.com/jezard/joulepersecond-go/utility"
+)
+
+//default work thresholds (kJ)
+var DefaultKj = []int{1000, 2000, 3000}
+
+//durations the curves are worked out for (seconds)
+var Durations = []int{5, 10, 20, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+//the user's thresholds, defaults where not set
+func Kj(user types.UserSettings) []int {
+	if len(user.DurabilityKj) == 0 {
+		return DefaultKj
+	}
+	return user.DurabilityKj
+}
+
+//the fresh curve (0 kJ) followed by a curve for each of the user's thresholds - a curve is left out once the ride didn't reach its threshold
+func Curves(powerSeries []int, user types.UserSettings) []types.DurabilityCurve {
+	curves := make([]types.DurabilityCurve, 0)
+	thresholds := append([]int{0}, Kj(user)...)
+
+	//cumulative work, joules at 1 sample per second
+	work := make([]int, len(powerSeries)+1)
+	for i, val := range powerSeries {
+		work[i+1] = work[i] + val
+	}
+	for _, kj := range thresholds {
+		start := 0
+		for start < len(powerSeries) && work[start] < kj*1000 {
+			start++
+		}
+		if start >= len(powerSeries) {
+			break
+		}
+		curves = append(curves, types.DurabilityCurve{Kj: kj, Points: meanMax(work[start:])})
+	}
+	return curves
+}
+
+//best average power for each duration from the cumulative work
+func meanMax(work []int) []types.MeanMax {
+	points := make([]types.MeanMax, 0)
+	samples := len(work) - 1
+	for _, secs := range Durations {
+		if secs > samples {
+			break
+		}
+		best := 0
+		for j := secs; j <= samples; j++ {
+			if sum := work[j] - work[j-secs]; sum > best {
+				best = sum
+			}
+		}
+		points = append(points, types.MeanMax{Seconds: secs, Watts: best / secs})
+	}
+	return points
+}
+
+//whether stored curves were worked out for other thresholds than the user's current ones (curves can be missing where the ride was short)
+func Stale(curves []types.DurabilityCurve, user types.UserSettings) bool {
+	if len(curves) == 0 {
+		return true
+	}
+	thresholds := append([]int{0}, Kj(user)...)
+	if len(curves) > len(thresholds) {
+		return true
+	}
+	for i, curve := range curves {
+		if curve.Kj != thresholds[i] {
+			return true
+		}
+	}
+	return false
+}
+
+//an activity's stored curves, only going back to the power series (fetched by load) if the user's thresholds have changed
+func ForActivity(summary types.Metrics, user types.UserSettings, load func() []int) []types.DurabilityCurve {
+	if !Stale(summary.Durability, user) {
+		return summary.Durability
+	}
+	return Curves(load(), user)
+}
+
+//an empty total for the user's current thresholds
+func Empty(user types.UserSettings) []types.DurabilityCurve {
+	total := make([]types.DurabilityCurve, 0)
+	for _, kj := range append([]int{0}, Kj(user)...) {
+		total = append(total, types.DurabilityCurve{Kj: kj, Points: make([]types.MeanMax, 0)})
+	}
+	return total
+}
+
+//keep the best power for each threshold and duration in a running total
+func Add(total []types.DurabilityCurve, curves []types.DurabilityCurve) {
+	for _, curve := range curves {
+		for c := range total {
+			if total[c].Kj != curve.Kj {
+				continue
+			}
+			for p, point := range curve.Points {
+				if p >= len(total[c].Points) {
+					total[c].Points = append(total[c].Points, point)
+				} else if point.Watts > total[c].Points[p].Watts {
+					total[c].Points[p].Watts = point.Watts
+				}
+			}
+		}
+	}
+}
+
+//a duration across the curves, with each fatigued value as a percentage of the fresh one
+type Row struct {
+	Seconds   int
+	Watts     []int     //one per curve, zero where no ride reached the threshold
+	Retention []float64 //percent of the fresh (0 kJ) power
+}
+
+//the curves as a table by duration
+func Rows(curves []types.DurabilityCurve) []Row {
+	rows := make([]Row, 0)
+	if len(curves) == 0 {
+		return rows
+	}
+	for p, fresh := range curves[0].Points {
+		row := Row{Seconds: fresh.Seconds}
+		for _, curve := range curves {
+			watts, retention := 0, 0.0
+			if p < len(curve.Points) {
+				watts = curve.Points[p].Watts
+			}
+			if fresh.Watts > 0 {
+				retention = utility.Round(float64(watts)/float64(fresh.Watts)*100, .5, 1)
+			}
+			row.Watts = append(row.Watts, watts)
+			row.Retention = append(row.Retention, retention)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
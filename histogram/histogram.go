@@ -0,0 +1,129 @@
+/* Power, heart rate and cadence distributions. Worked out per activity when it's processed (at the user's bin widths) and summed over any period for the analysis page */
+package histogram
+
+import (
+	"github.com/jezard/joulepersecond-go/types"
+)
+
+//default bin widths
+const (
+	DefaultPower   = 10 //watts
+	DefaultHeart   = 2  //bpm
+	DefaultCadence = 5  //rpm
+)
+
+//the user's bin widths, defaults where not set
+func Bins(user types.UserSettings) (bins types.HistogramBins) {
+	bins = user.Bins
+	if bins.Power < 1 {
+		bins.Power = DefaultPower
+	}
+	if bins.Heart < 1 {
+		bins.Heart = DefaultHeart
+	}
+	if bins.Cadence < 1 {
+		bins.Cadence = DefaultCadence
+	}
+	return
+}
+
+//histograms for a stretch of the time series - zero power (coasting) is counted, zero heart rate and cadence (no data, freewheeling) are not
+func ForSeries(powerSeries, heartSeries, cadenceSeries []int, user types.UserSettings) (histograms types.Histograms) {
+	bins := Bins(user)
+	histograms.Power = New(powerSeries, bins.Power, false)
+	histograms.Heart = New(heartSeries, bins.Heart, true)
+	histograms.Cadence = New(cadenceSeries, bins.Cadence, true)
+	return
+}
+
+//seconds at each value of the series grouped by width
+func New(series []int, width int, skipZero bool) (h types.Histogram) {
+	h.Width = width
+	h.Counts = make([]int, 0)
+	hasData := false
+	for _, val := range series {
+		if val > 0 {
+			hasData = true
+			break
+		}
+	}
+	if !hasData { //leave empty rather than a single bin of zeros
+		return
+	}
+	for _, val := range series {
+		if val < 0 || (val == 0 && skipZero) {
+			continue
+		}
+		bin := val / width
+		for len(h.Counts) <= bin {
+			h.Counts = append(h.Counts, 0)
+		}
+		h.Counts[bin]++
+	}
+	return
+}
+
+//regroup a histogram into wider bins - exact where the new width is a multiple of the old one
+func Rebin(h types.Histogram, width int) (rebinned types.Histogram) {
+	rebinned.Width = width
+	rebinned.Counts = make([]int, 0)
+	if h.Width < 1 {
+		return
+	}
+	for i, count := range h.Counts {
+		bin := (i * h.Width) / width
+		for len(rebinned.Counts) <= bin {
+			rebinned.Counts = append(rebinned.Counts, 0)
+		}
+		rebinned.Counts[bin] += count
+	}
+	return
+}
+
+//whether a stored histogram can be regrouped exactly to the width
+func fits(h types.Histogram, width int) bool {
+	return h.Width > 0 && width%h.Width == 0
+}
+
+//an activity's stored histograms at the user's bin widths, only going back to the time series (fetched by load) where they can't be regrouped exactly
+func ForActivity(summary types.Metrics, user types.UserSettings, load func() (powerSeries, heartSeries, cadenceSeries []int)) types.Histograms {
+	bins := Bins(user)
+	stored := summary.Histograms
+	if !fits(stored.Power, bins.Power) || !fits(stored.Heart, bins.Heart) || !fits(stored.Cadence, bins.Cadence) {
+		powerSeries, heartSeries, cadenceSeries := load()
+		return ForSeries(powerSeries, heartSeries, cadenceSeries, user)
+	}
+	return types.Histograms{
+		Power:   Rebin(stored.Power, bins.Power),
+		Heart:   Rebin(stored.Heart, bins.Heart),
+		Cadence: Rebin(stored.Cadence, bins.Cadence),
+	}
+}
+
+//an empty total at the user's bin widths
+func Empty(user types.UserSettings) (histograms types.Histograms) {
+	bins := Bins(user)
+	histograms.Power = types.Histogram{Width: bins.Power, Counts: make([]int, 0)}
+	histograms.Heart = types.Histogram{Width: bins.Heart, Counts: make([]int, 0)}
+	histograms.Cadence = types.Histogram{Width: bins.Cadence, Counts: make([]int, 0)}
+	return
+}
+
+//add one activity's histograms to a running total (regrouping to the total's widths where they differ)
+func Add(total *types.Histograms, histograms types.Histograms) {
+	add(&total.Power, histograms.Power)
+	add(&total.Heart, histograms.Heart)
+	add(&total.Cadence, histograms.Cadence)
+}
+
+func add(total *types.Histogram, h types.Histogram) {
+	if h.Width != total.Width {
+		h = Rebin(h, total.Width)
+	}
+	for i, count := range h.Counts {
+		for len(total.Counts) <= i {
+			total.Counts = append(total.Counts, 0)
+		}
+		total.Counts[i] += count
+	}
+}
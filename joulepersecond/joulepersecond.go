@@ -4,6 +4,8 @@ import (
 	"github.com/jezard/joulepersecond-go/activity"
 	"github.com/jezard/joulepersecond-go/analysis"
 	"github.com/jezard/joulepersecond-go/dashboard"
+	"github.com/jezard/joulepersecond-go/plan"
+	"github.com/jezard/joulepersecond-go/weight"
 	"net/http"
 )
 
@@ -11,17 +13,26 @@ func main() {
 
 	//test dashboard
 	http.HandleFunc("/dashboard/", dashboard.DashboardHandler)
+	http.HandleFunc("/records/", dashboard.RecordsHandler) //personal records (JSON)
 
 	//activity routes
 	http.HandleFunc("/activity", activity.ActivityHandler)
 	http.HandleFunc("/view/activity/", activity.ActivityHandler)
+	http.HandleFunc("/view/ve/", activity.ActivityHandler) //virtual elevation (JSON)
 	http.HandleFunc("/process/activity/", activity.ActivityHandler)
 	http.HandleFunc("/process/file/", activity.ActivityHandler)
 	http.HandleFunc("/delete/activity/", activity.ActivityHandler)
 
+	//weight log (JSON)
+	http.HandleFunc("/weight/", weight.WeightHandler)
+
+	//training plan (JSON)
+	http.HandleFunc("/plan/", plan.PlanHandler)
+
 	//analysis routes
 	http.HandleFunc("/analysis?", analysis.AnalysisHandler)
 	http.HandleFunc("/analysis/", analysis.AnalysisHandler)
+	http.HandleFunc("/ff/", analysis.FfHandler) //fitness and freshness (JSON)
 
 	//static file handler.
 	http.Handle("/assets/", http.StripPrefix("/assets/", http.FileServer(http.Dir("assets"))))
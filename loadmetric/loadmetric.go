@@ -0,0 +1,205 @@
+/* Training load metrics. Each metric works out an activity's training load from its 1 second time series, so the user can choose which one drives the fitness/freshness and training load charts */
+package loadmetric
+
+import (
+	"github.com/jezard/joulepersecond-go/types"
+	"math"
+	"time"
+)
+
+//names of the available metrics (as stored in the user's settings)
+const (
+	Tss           = "tss"            //Coggan's TSS (calculated with the rest of the power metrics in activity processing)
+	BikeScore     = "bikescore"      //Skiba's BikeScore from xPower
+	HrTss         = "hrtss"          //Banister TRIMP calibrated against an hour at threshold heart rate
+	TrimpBanister = "trimp_banister" //Banister's TRIMP
+	TrimpEdwards  = "trimp_edwards"  //Edwards' summated heart rate zone TRIMP
+	TrimpLucia    = "trimp_lucia"    //Lucia's three zone TRIMP
+)
+
+//session RPE load isn't one of them - it comes from the rider's rating rather than the time series, and is kept as its
+//own series (see SessionRpeLoad)
+
+//a load metric calculates the training load of an activity from its power and heart rate series (1 sample per second)
+type Calculator func(powerSeries, heartSeries []int, user types.UserSettings) int
+
+//all metrics calculated when an activity is processed (TSS is calculated along with NP and IF)
+var Calculators = map[string]Calculator{
+	BikeScore:     bikeScore,
+	HrTss:         hrTss,
+	TrimpBanister: trimpBanister,
+	TrimpEdwards:  trimpEdwards,
+	TrimpLucia:    trimpLucia,
+}
+
+//human readable metric names
+var Labels = map[string]string{
+	Tss:           "Training load (power)",
+	BikeScore:     "BikeScore",
+	HrTss:         "Training load (heart rate)",
+	TrimpBanister: "TRIMP (Banister)",
+	TrimpEdwards:  "TRIMP (Edwards)",
+	TrimpLucia:    "TRIMP (Lucia)",
+}
+
+//calculate every metric for an activity
+func All(powerSeries, heartSeries []int, user types.UserSettings) map[string]int {
+	loads := make(map[string]int)
+	for name, calculate := range Calculators {
+		loads[name] = calculate(powerSeries, heartSeries, user)
+	}
+	return loads
+}
+
+//metrics worked out from power - power estimated from speed only counts towards them where the user has chosen to count it
+var powerBased = map[string]bool{Tss: true, BikeScore: true}
+
+//training load for an activity from the user's chosen metric, 0 where the activity doesn't have it (see Missing) - bar
+//TSS, which falls back to the heart rate estimate (on the same scale) for rides without power
+func Value(summary types.Metrics, user types.UserSettings) int {
+	uncounted := summary.EstimatedPower && !user.UseEstimated && powerBased[user.LoadMetric]
+	if val := summary.Load[user.LoadMetric]; val > 0 && !uncounted {
+		return val
+	}
+	if user.LoadMetric == Tss || user.LoadMetric == "" {
+		return summary.Etss
+	}
+	return 0
+}
+
+//whether an activity is missing the user's chosen metric, so adds no load to their charts (unless they've set one)
+func Missing(summary types.Metrics, user types.UserSettings) bool {
+	return Value(summary, user) == 0
+}
+
+//Foster's session RPE load = RPE (CR-10 scale) x duration in minutes
+func SessionRpeLoad(rpe int, dur time.Duration) int {
+	if rpe <= 0 {
+		return 0
+	}
+	return rpe * int(dur.Minutes())
+}
+
+//Skiba's xPower - 25 second exponentially weighted average of power, raised to the 4th, averaged and rooted
+func XPower(powerSeries []int) int {
+	if len(powerSeries) == 0 {
+		return 0
+	}
+	const timeConstant = 25.0
+	var weighted, fourthPower float64
+	for _, val := range powerSeries {
+		weighted += (float64(val) - weighted) / timeConstant
+		fourthPower += math.Pow(weighted, 4)
+	}
+	return int(math.Pow(fourthPower/float64(len(powerSeries)), 0.25))
+}
+
+//BikeScore = duration(s) x xPower x relative intensity / (FTP x 3600) x 100
+func bikeScore(powerSeries, heartSeries []int, user types.UserSettings) int {
+	if user.Ftp == 0 {
+		return 0
+	}
+	xPower := XPower(powerSeries)
+	relativeIntensity := float64(xPower) / float64(user.Ftp)
+	return int((float64(len(powerSeries)) * float64(xPower) * relativeIntensity) / (float64(user.Ftp) * 3600) * 100)
+}
+
+//maximum heart rate - the user's own where set, else estimated from threshold heart rate
+func maxHr(user types.UserSettings) float64 {
+	if user.Mhr > 0 {
+		return float64(user.Mhr)
+	}
+	return float64(user.Thr) * 1.06
+}
+
+//Banister's weighting for a second at a heart rate reserve fraction
+func banisterWeight(hrReserve float64, user types.UserSettings) float64 {
+	if user.Gender == "female" {
+		return hrReserve * 0.86 * math.Exp(1.67*hrReserve)
+	}
+	return hrReserve * 0.64 * math.Exp(1.92*hrReserve)
+}
+
+//heart rate reserve fraction using the user's resting heart rate (Karvonen)
+func hrReserve(hr int, user types.UserSettings) float64 {
+	reserve := (float64(hr) - float64(user.Rhr)) / (maxHr(user) - float64(user.Rhr))
+	if reserve < 0 {
+		return 0
+	}
+	return reserve
+}
+
+//Banister TRIMP = sum over minutes of HRr x weighting
+func banisterSum(heartSeries []int, user types.UserSettings) float64 {
+	var trimp float64
+	for _, val := range heartSeries {
+		if val == 0 {
+			continue //no hr data
+		}
+		trimp += banisterWeight(hrReserve(val, user), user) / 60 //each sample is a second
+	}
+	return trimp
+}
+
+func trimpBanister(powerSeries, heartSeries []int, user types.UserSettings) int {
+	if user.Thr == 0 {
+		return 0
+	}
+	return int(banisterSum(heartSeries, user))
+}
+
+//hrTSS - Banister TRIMP relative to an hour at threshold heart rate, so 100 = 1 hour at threshold
+func hrTss(powerSeries, heartSeries []int, user types.UserSettings) int {
+	if user.Thr == 0 || user.Thr <= user.Rhr {
+		return 0
+	}
+	thresholdHour := banisterWeight(hrReserve(user.Thr, user), user) * 60
+	return int(banisterSum(heartSeries, user) / thresholdHour * 100)
+}
+
+//Edwards TRIMP - minutes in each 10% band of max heart rate from 50%, weighted 1 to 5
+func trimpEdwards(powerSeries, heartSeries []int, user types.UserSettings) int {
+	if user.Thr == 0 {
+		return 0
+	}
+	var trimp float64
+	for _, val := range heartSeries {
+		percentMax := float64(val) / maxHr(user)
+		if percentMax >= 0.9 {
+			trimp += 5
+		} else if percentMax >= 0.8 {
+			trimp += 4
+		} else if percentMax >= 0.7 {
+			trimp += 3
+		} else if percentMax >= 0.6 {
+			trimp += 2
+		} else if percentMax >= 0.5 {
+			trimp += 1
+		}
+	}
+	return int(trimp / 60)
+}
+
+//Lucia TRIMP - minutes below the first ventilatory threshold x1, between thresholds x2 and above the second x3.
+//The thresholds are taken from threshold heart rate as the top of zone 2 (VT1) and threshold heart rate itself (VT2)
+func trimpLucia(powerSeries, heartSeries []int, user types.UserSettings) int {
+	if user.Thr == 0 {
+		return 0
+	}
+	vt1 := 0.89 * float64(user.Thr)
+	vt2 := float64(user.Thr)
+	var trimp float64
+	for _, val := range heartSeries {
+		if val == 0 {
+			continue //no hr data
+		}
+		if float64(val) > vt2 {
+			trimp += 3
+		} else if float64(val) > vt1 {
+			trimp += 2
+		} else {
+			trimp += 1
+		}
+	}
+	return int(trimp / 60)
+}
@@ -0,0 +1,41 @@
+package loadmetric
+
+import (
+	"github.com/jezard/joulepersecond-go/types"
+	"testing"
+)
+
+func TestValue(t *testing.T) {
+	withPower := types.Metrics{Tss: 80, Etss: 70, Load: map[string]int{Tss: 80, BikeScore: 85, HrTss: 75}}
+	heartOnly := types.Metrics{Etss: 70, Load: map[string]int{HrTss: 75}}
+	estimated := withPower
+	estimated.EstimatedPower = true
+	noData := types.Metrics{}
+	tests := []struct {
+		name         string
+		summary      types.Metrics
+		metric       string
+		useEstimated bool
+		want         int
+	}{
+		{"chosen metric", withPower, BikeScore, false, 85},
+		{"tss", withPower, Tss, false, 80},
+		{"tss from heart rate without power", heartOnly, Tss, false, 70},
+		{"no other metric in its place", heartOnly, BikeScore, false, 0},
+		{"heart rate metric", heartOnly, HrTss, false, 75},
+		{"nothing", noData, TrimpEdwards, false, 0},
+		{"estimated power not counted", estimated, Tss, false, 70},
+		{"estimated power not counted for a power metric", estimated, BikeScore, false, 0},
+		{"estimated power not counted leaves heart rate metrics", estimated, HrTss, false, 75},
+		{"estimated power counted", estimated, BikeScore, true, 85},
+	}
+	for _, test := range tests {
+		user := types.UserSettings{LoadMetric: test.metric, UseEstimated: test.useEstimated}
+		if got := Value(test.summary, user); got != test.want {
+			t.Errorf("%s: Value = %d, want %d", test.name, got, test.want)
+		}
+		if missing := Missing(test.summary, user); missing != (test.want == 0) {
+			t.Errorf("%s: Missing = %t", test.name, missing)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+/* Calendar periods. Splits a date range into days, weeks (starting on the user's chosen day), months or years in the user's time zone, so the weekly and monthly charts bucket activities by real calendar dates - across year boundaries, and with a bucket for every period whether or not it has any activities */
+package periods
+
+import (
+	"strconv"
+	"time"
+)
+
+//period units
+const (
+	Day   = "day"
+	Week  = "week"
+	Month = "month"
+	Year  = "year"
+)
+
+var Units = []string{Day, Week, Month, Year}
+
+//a period [From, To)
+type Period struct {
+	From, To time.Time
+	Label    string
+}
+
+//a unit from the filter, or the default for the history length - months over a year, otherwise weeks
+func Unit(unit string, historyLen int) string {
+	for _, u := range Units {
+		if unit == u {
+			return u
+		}
+	}
+	if historyLen > 366 {
+		return Month
+	}
+	return Week
+}
+
+//the start of the period a time falls in
+func Start(t time.Time, unit string, weekStart time.Weekday, loc *time.Location) time.Time {
+	t = t.In(loc)
+	year, month, day := t.Date()
+	switch unit {
+	case Week:
+		offset := (int(t.Weekday()) - int(weekStart) + 7) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, loc)
+	case Month:
+		return time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	case Year:
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+//the start of the period after the one starting at start
+func next(start time.Time, unit string) time.Time {
+	switch unit {
+	case Week:
+		return start.AddDate(0, 0, 7)
+	case Month:
+		return start.AddDate(0, 1, 0)
+	case Year:
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+//every period from the one from falls in to the one to falls in - long labels give a week's first and last days
+func Range(from, to time.Time, unit string, weekStart time.Weekday, loc *time.Location, long bool) []Period {
+	periods := make([]Period, 0)
+	end := next(Start(to, unit, weekStart, loc), unit)
+	for start := Start(from, unit, weekStart, loc); start.Before(end); start = next(start, unit) {
+		p := Period{From: start, To: next(start, unit)}
+		p.Label = label(p, unit, long)
+		periods = append(periods, p)
+	}
+	return periods
+}
+
+//the index of the period a time falls in, -1 where it's outside them all
+func Index(periods []Period, t time.Time) int {
+	for i, p := range periods {
+		if !t.Before(p.From) && t.Before(p.To) {
+			return i
+		}
+	}
+	return -1
+}
+
+//a period's label e.g. 5 Jan, 5 Jan - 11 Jan, Jan '15 or 2015
+func label(p Period, unit string, long bool) string {
+	switch unit {
+	case Week:
+		if long {
+			return p.From.Format("2 Jan") + " - " + p.To.AddDate(0, 0, -1).Format("2 Jan")
+		}
+		return p.From.Format("2 Jan")
+	case Month:
+		return p.From.Format("Jan '06")
+	case Year:
+		return strconv.Itoa(p.From.Year())
+	}
+	return p.From.Format("2 Jan")
+}
+
+//a chart legend for the unit
+func Legend(unit string, weekStart time.Weekday) string {
+	switch unit {
+	case Week:
+		return "By week (starting " + weekStart.String() + ")"
+	case Month:
+		return "By month"
+	case Year:
+		return "By year"
+	}
+	return "By day"
+}
@@ -0,0 +1,113 @@
+package periods
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	london   = time.FixedZone("GMT", 0)
+	auckland = time.FixedZone("NZDT", 13*60*60)
+)
+
+func TestStart(t *testing.T) {
+	newYear := time.Date(2026, time.January, 1, 12, 0, 0, 0, london) //a Thursday
+	lateUtc := time.Date(2025, time.December, 31, 23, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		t         time.Time
+		unit      string
+		weekStart time.Weekday
+		loc       *time.Location
+		want      time.Time
+	}{
+		{"week from Monday back over the year end", newYear, Week, time.Monday, london, time.Date(2025, time.December, 29, 0, 0, 0, 0, london)},
+		{"week from Sunday", newYear, Week, time.Sunday, london, time.Date(2025, time.December, 28, 0, 0, 0, 0, london)},
+		{"week starting on the day", newYear, Week, time.Thursday, london, time.Date(2026, time.January, 1, 0, 0, 0, 0, london)},
+		{"week from Friday", newYear, Week, time.Friday, london, time.Date(2025, time.December, 26, 0, 0, 0, 0, london)},
+		{"day", newYear, Day, time.Monday, london, time.Date(2026, time.January, 1, 0, 0, 0, 0, london)},
+		{"month", newYear, Month, time.Monday, london, time.Date(2026, time.January, 1, 0, 0, 0, 0, london)},
+		{"year", newYear, Year, time.Monday, london, time.Date(2026, time.January, 1, 0, 0, 0, 0, london)},
+		{"month in the user's zone", lateUtc, Month, time.Monday, london, time.Date(2025, time.December, 1, 0, 0, 0, 0, london)},
+		{"month already the new year in the user's zone", lateUtc, Month, time.Monday, auckland, time.Date(2026, time.January, 1, 0, 0, 0, 0, auckland)},
+		{"year already the new year in the user's zone", lateUtc, Year, time.Monday, auckland, time.Date(2026, time.January, 1, 0, 0, 0, 0, auckland)},
+	}
+	for _, test := range tests {
+		if got := Start(test.t, test.unit, test.weekStart, test.loc); !got.Equal(test.want) {
+			t.Errorf("%s: Start = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	from := time.Date(2025, time.December, 20, 9, 0, 0, 0, london)
+	to := time.Date(2026, time.January, 14, 18, 0, 0, 0, london)
+	tests := []struct {
+		unit      string
+		weekStart time.Weekday
+		long      bool
+		want      []string
+	}{
+		{Week, time.Monday, false, []string{"15 Dec", "22 Dec", "29 Dec", "5 Jan", "12 Jan"}},
+		{Week, time.Sunday, false, []string{"14 Dec", "21 Dec", "28 Dec", "4 Jan", "11 Jan"}},
+		{Week, time.Monday, true, []string{"15 Dec - 21 Dec", "22 Dec - 28 Dec", "29 Dec - 4 Jan", "5 Jan - 11 Jan", "12 Jan - 18 Jan"}},
+		{Month, time.Monday, false, []string{"Dec '25", "Jan '26"}},
+		{Year, time.Monday, false, []string{"2025", "2026"}},
+	}
+	for _, test := range tests {
+		got := Range(from, to, test.unit, test.weekStart, london, test.long)
+		if len(got) != len(test.want) {
+			t.Errorf("%s from %v: got %d periods, want %d", test.unit, test.weekStart, len(got), len(test.want))
+			continue
+		}
+		for i, p := range got {
+			if p.Label != test.want[i] {
+				t.Errorf("%s from %v: period %d label = %q, want %q", test.unit, test.weekStart, i, p.Label, test.want[i])
+			}
+			if i > 0 && !p.From.Equal(got[i-1].To) {
+				t.Errorf("%s from %v: period %d starts at %v, not at the end of the one before", test.unit, test.weekStart, i, p.From)
+			}
+		}
+	}
+	if days := Range(from, to, Day, time.Monday, london, false); len(days) != 26 {
+		t.Errorf("got %d days, want 26", len(days))
+	}
+}
+
+func TestIndex(t *testing.T) {
+	weeks := Range(time.Date(2025, time.December, 29, 0, 0, 0, 0, london), time.Date(2026, time.January, 11, 0, 0, 0, 0, london), Week, time.Monday, london, false)
+	tests := []struct {
+		t    time.Time
+		want int
+	}{
+		{time.Date(2025, time.December, 28, 23, 59, 0, 0, london), -1},
+		{time.Date(2025, time.December, 29, 0, 0, 0, 0, london), 0},
+		{time.Date(2026, time.January, 4, 23, 59, 0, 0, london), 0},
+		{time.Date(2026, time.January, 5, 0, 0, 0, 0, london), 1},
+		{time.Date(2026, time.January, 4, 23, 30, 0, 0, time.FixedZone("", -60*60)), 1}, //Monday in London
+		{time.Date(2026, time.January, 12, 0, 0, 0, 0, london), -1},
+	}
+	for _, test := range tests {
+		if got := Index(weeks, test.t); got != test.want {
+			t.Errorf("Index(%v) = %d, want %d", test.t, got, test.want)
+		}
+	}
+}
+
+func TestUnit(t *testing.T) {
+	tests := []struct {
+		unit       string
+		historyLen int
+		want       string
+	}{
+		{"", 90, Week},
+		{"", 367, Month},
+		{"year", 90, Year},
+		{"fortnight", 90, Week},
+	}
+	for _, test := range tests {
+		if got := Unit(test.unit, test.historyLen); got != test.want {
+			t.Errorf("Unit(%q, %d) = %q, want %q", test.unit, test.historyLen, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+/* Training plan. Planned daily load - either a TSS value or a workout (duration and intensity) - kept in the user_plan table and used to project fitness and freshness forward to an event */
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/jezard/joulepersecond-go/conf"
+	"github.com/jezard/joulepersecond-go/types"
+	"github.com/jezard/joulepersecond-go/usersettings"
+	"github.com/jezard/joulepersecond-go/utility"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//date format for the API
+const DateFormat = "2006-01-02"
+
+var config = conf.Configuration()
+
+//a planned day
+type Entry struct {
+	Date    time.Time
+	Tss     int     //planned load - worked out from the workout where not given
+	Workout string  //e.g. 3x10min sweet spot
+	Minutes int     //workout duration
+	If      float64 //workout intensity factor
+}
+
+//a workout's load (TSS) from its duration and intensity
+func WorkoutTss(minutes int, intensity float64) int {
+	return int(float64(minutes)/60*intensity*intensity*100 + .5)
+}
+
+//the planned load on each day, keyed by date (DateFormat)
+func Daily(entries []Entry) map[string]int {
+	daily := make(map[string]int)
+	for _, entry := range entries {
+		daily[entry.Date.Format(DateFormat)] += entry.Tss
+	}
+	return daily
+}
+
+//the user's plan in date order between from and to
+func Get(user types.UserSettings, from, to time.Time) []Entry {
+	entries := make([]Entry, 0)
+	var entry Entry
+
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	iter := session.Query(`SELECT plan_date, tss, workout, minutes, intensity FROM joulepersecond.user_plan WHERE user_id = ? AND plan_date >= ? AND plan_date <= ? ORDER BY plan_date ASC`, user.Id, from, to).Iter()
+	for iter.Scan(&entry.Date, &entry.Tss, &entry.Workout, &entry.Minutes, &entry.If) {
+		entries = append(entries, entry)
+	}
+	if err := iter.Close(); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	return entries
+}
+
+//add or replace a day's plan
+func Save(user types.UserSettings, entry Entry) {
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	if err := session.Query(`INSERT INTO user_plan (user_id, plan_date, tss, workout, minutes, intensity) VALUES (?, ?, ?, ?, ?, ?)`,
+		user.Id, entry.Date, entry.Tss, entry.Workout, entry.Minutes, entry.If).Exec(); err != nil {
+		log.Printf("Location:%v", err)
+	}
+}
+
+//remove a day's plan
+func Delete(user types.UserSettings, date time.Time) {
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	if err := session.Query(`DELETE FROM user_plan WHERE user_id = ? AND plan_date = ?`, user.Id, date).Exec(); err != nil {
+		log.Printf("Location:%v", err)
+	}
+}
+
+//a planned day from the API values - either tss, or minutes and intensity (IF) for a workout
+func Parse(date, tss, workout, minutes, intensity string) (entry Entry, err error) {
+	entry.Date, err = time.Parse(DateFormat, strings.TrimSpace(date))
+	if err != nil {
+		return entry, errors.New("Date must be in the format " + DateFormat)
+	}
+	entry.Workout = strings.TrimSpace(workout)
+	entry.Minutes, _ = strconv.Atoi(strings.TrimSpace(minutes))
+	entry.If, _ = strconv.ParseFloat(strings.TrimSpace(intensity), 64)
+	if tss = strings.TrimSpace(tss); tss != "" {
+		entry.Tss, err = strconv.Atoi(tss)
+		if err != nil || entry.Tss < 0 {
+			return entry, errors.New("TSS must be a whole number")
+		}
+		return entry, nil
+	}
+	if entry.Minutes <= 0 || entry.If <= 0 {
+		return entry, errors.New("Give a TSS value, or a workout duration (minutes) and intensity factor")
+	}
+	entry.Tss = WorkoutTss(entry.Minutes, entry.If)
+	return entry, nil
+}
+
+//the plan API
+//
+//	GET /plan/<token>?from=&to= lists planned days (from today by default)
+//	POST /plan/<token> with date and tss, or date, workout, minutes and if, adds (or replaces) a day
+//	DELETE /plan/<token>?date= removes a day
+func PlanHandler(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token")
+
+	urlparts := strings.Split(r.URL.Path[1:], "/")
+	if len(urlparts) < 2 || urlparts[1] == "" {
+		http.Error(w, "Malformed URL", http.StatusBadRequest)
+		return
+	}
+	access_token, err := url.QueryUnescape(urlparts[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := Usersettings.Get(access_token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" && user.Demo {
+		http.Error(w, "Forbidden: Cannot complete this task.", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		entry, err := Parse(r.FormValue("date"), r.FormValue("tss"), r.FormValue("workout"), r.FormValue("minutes"), r.FormValue("if"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		Save(user, entry)
+		utility.WriteJSON(w, entry)
+	case "DELETE":
+		date, err := time.Parse(DateFormat, r.FormValue("date"))
+		if err != nil {
+			http.Error(w, "Date must be in the format "+DateFormat, http.StatusBadRequest)
+			return
+		}
+		Delete(user, date)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		year, month, day := time.Now().Date()
+		from, err := time.Parse(DateFormat, r.FormValue("from"))
+		if err != nil {
+			from = time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		}
+		to, err := time.Parse(DateFormat, r.FormValue("to"))
+		if err != nil {
+			to = from.AddDate(1, 0, 0)
+		}
+		utility.WriteJSON(w, Get(user, from, to))
+	}
+}
@@ -0,0 +1,144 @@
+/* Power profiling. The rider's best 5 second, 1 minute, 5 minute and threshold power in W/kg (using the weight on the ride date) are placed on a Coggan style power profile table from untrained to world class, and the shape of the profile labels the rider a sprinter, pursuiter, time triallist or all-rounder */
+package profile
+
+import (
+	"github.com/jezard/joulepersecond-go/types"
+	"github.com/jezard/joulepersecond-go/utility"
+)
+
+//profile durations, in the order used throughout
+var Durations = [4]string{"5s", "1min", "5min", "FTP"}
+
+//categories from the bottom of the table up - each covers an equal share of the range between untrained and world class
+var Categories = []string{"Untrained", "Fair", "Moderate", "Good", "Very good", "Excellent", "Exceptional", "World class"}
+
+//W/kg at the bottom (untrained) and top (world class) of the table for each duration
+var table = map[string][2][4]float64{
+	"male":   {{10.17, 5.64, 2.33, 1.86}, {25.18, 11.50, 7.60, 6.40}},
+	"female": {{8.28, 4.53, 1.99, 1.50}, {19.42, 9.29, 6.61, 5.69}},
+}
+
+//rider types, from the pair of durations the rider scores best at
+const (
+	Sprinter      = "Sprinter"
+	Pursuiter     = "Pursuiter"
+	TimeTriallist = "Time triallist"
+	AllRounder    = "All-rounder"
+)
+
+//scores (percent of the table's range) within this many points of each other make an all-rounder
+const allRounderSpread = 10.0
+
+//a duration's place on the table
+type Level struct {
+	Duration string
+	Wkg      float64
+	Score    float64 //percent of the way from untrained to world class (can go over 100)
+	Category string
+}
+
+//a rider's power profile for a period
+type Profile struct {
+	Label     string
+	Levels    [4]Level
+	RiderType string
+	HasData   bool
+}
+
+//threshold power from the bests - the best hour, or 95% of the best 20 minutes where that's higher
+func Ftp(cpms types.CPMs) int {
+	ftp := cpms.TwentyMinuteCP * 95 / 100
+	if cpms.SixtyMinuteCP > ftp {
+		ftp = cpms.SixtyMinuteCP
+	}
+	return ftp
+}
+
+//W/kg for each of the profile durations from a ride's bests - ftp is used where the ride had no 20 minute effort
+func Wkg(cpms types.CPMs, weight float64, ftp int) (wkg [4]float64) {
+	if weight <= 0 {
+		return
+	}
+	if best := Ftp(cpms); best > 0 {
+		ftp = best
+	}
+	for i, watts := range [4]int{cpms.FiveSecondCP, cpms.SixtySecondCP, cpms.FiveMinuteCP, ftp} {
+		wkg[i] = float64(watts) / weight
+	}
+	return
+}
+
+//keep the best W/kg for each duration in a running total
+func Add(total *[4]float64, wkg [4]float64) {
+	for i := range wkg {
+		if wkg[i] > total[i] {
+			total[i] = wkg[i]
+		}
+	}
+}
+
+//place the bests on the table
+func Classify(label string, wkg [4]float64, gender string) (p Profile) {
+	p.Label = label
+	limits, ok := table[gender]
+	if !ok {
+		limits = table["male"]
+	}
+	var scores [4]float64
+	for i := range wkg {
+		p.Levels[i].Duration = Durations[i]
+		if wkg[i] <= 0 {
+			continue
+		}
+		p.HasData = true
+		scores[i] = (wkg[i] - limits[0][i]) / (limits[1][i] - limits[0][i]) * 100
+		p.Levels[i].Wkg = utility.Round(wkg[i], .5, 2)
+		p.Levels[i].Score = utility.Round(scores[i], .5, 1)
+		p.Levels[i].Category = Category(scores[i])
+	}
+	for i := range wkg {
+		if wkg[i] <= 0 { //a rider type needs all four durations
+			return
+		}
+	}
+	p.RiderType = RiderType(scores)
+	return
+}
+
+//the category for a score
+func Category(score float64) string {
+	band := int(score / (100 / float64(len(Categories)-1)))
+	if band < 0 {
+		band = 0
+	}
+	if band >= len(Categories) {
+		band = len(Categories) - 1
+	}
+	return Categories[band]
+}
+
+//the rider type from the scores at each duration
+func RiderType(scores [4]float64) string {
+	low, high := scores[0], scores[0]
+	for _, score := range scores {
+		if score < low {
+			low = score
+		}
+		if score > high {
+			high = score
+		}
+	}
+	if high-low <= allRounderSpread {
+		return AllRounder
+	}
+	sprint := (scores[0] + scores[1]) / 2
+	pursuit := (scores[1] + scores[2]) / 2
+	tt := (scores[2] + scores[3]) / 2
+	switch {
+	case sprint >= pursuit && sprint >= tt:
+		return Sprinter
+	case pursuit >= tt:
+		return Pursuiter
+	}
+	return TimeTriallist
+}
@@ -0,0 +1,163 @@
+/* Quadrant analysis. Each second's power and cadence becomes an average effective pedal force (AEPF) and circumferential pedal velocity (CPV), which are split into four quadrants around the rider's FTP point (FTP ridden at their FTP cadence) */
+package quadrant
+
+import (
+	"github.com/jezard/joulepersecond-go/types"
+	"github.com/jezard/joulepersecond-go/utility"
+	"math"
+)
+
+//defaults where the user hasn't set their own
+const (
+	DefaultCrankLength = 172.5 //mm
+	DefaultCadence     = 90    //rpm
+)
+
+//most points drawn on a scatter chart, longer rides are thinned out
+const maxPoints = 3000
+
+//quadrant names, in the same order as types.Quadrants.Seconds
+var Labels = [4]string{
+	"I - high force, high velocity",
+	"II - high force, low velocity",
+	"III - low force, low velocity",
+	"IV - low force, high velocity",
+}
+
+//a second of the ride
+type Point struct {
+	Cpv  float64 //m/s
+	Aepf float64 //newtons
+}
+
+//quadrant data ready for display
+type Summary struct {
+	types.Quadrants
+	Percent         [4]float64
+	FtpCpv, FtpAepf float64
+	Points          []Point //for the scatter chart - empty for a period
+	HasData         bool
+}
+
+//the user's crank length (mm)
+func CrankLength(user types.UserSettings) float64 {
+	if user.CrankLength <= 0 {
+		return DefaultCrankLength
+	}
+	return user.CrankLength
+}
+
+//the cadence of the user's FTP point
+func Cadence(user types.UserSettings) int {
+	if user.FtpCadence < 1 {
+		return DefaultCadence
+	}
+	return user.FtpCadence
+}
+
+//circumferential pedal velocity (m/s)
+func Cpv(cadence int, crankLength float64) float64 {
+	return float64(cadence) * crankLength / 1000 * 2 * math.Pi / 60
+}
+
+//average effective pedal force (N)
+func Aepf(power, cadence int, crankLength float64) float64 {
+	if cadence <= 0 {
+		return 0
+	}
+	return float64(power) / Cpv(cadence, crankLength)
+}
+
+//seconds in each quadrant around the FTP point - seconds without pedalling (no power or cadence) aren't counted
+func Split(powerSeries, cadenceSeries []int, user types.UserSettings, ftp int) (q types.Quadrants) {
+	q.Ftp = ftp
+	q.Cadence = Cadence(user)
+	q.CrankLength = CrankLength(user)
+	if ftp <= 0 {
+		return
+	}
+	ftpCpv := Cpv(q.Cadence, q.CrankLength)
+	ftpAepf := Aepf(ftp, q.Cadence, q.CrankLength)
+	for i := range powerSeries {
+		if i >= len(cadenceSeries) || powerSeries[i] <= 0 || cadenceSeries[i] <= 0 {
+			continue
+		}
+		q.Seconds[index(Cpv(cadenceSeries[i], q.CrankLength), Aepf(powerSeries[i], cadenceSeries[i], q.CrankLength), ftpCpv, ftpAepf)]++
+	}
+	return
+}
+
+//which quadrant a point falls in
+func index(cpv, aepf, ftpCpv, ftpAepf float64) int {
+	switch {
+	case aepf >= ftpAepf && cpv >= ftpCpv:
+		return 0
+	case aepf >= ftpAepf:
+		return 1
+	case cpv < ftpCpv:
+		return 2
+	}
+	return 3
+}
+
+//whether stored quadrants were split with other crank length or cadence settings than the user's current ones
+func Stale(q types.Quadrants, user types.UserSettings) bool {
+	return q.Ftp == 0 || q.Cadence != Cadence(user) || q.CrankLength != CrankLength(user)
+}
+
+//an activity's stored quadrants, only splitting the time series (fetched by load) again where the user's settings have since changed
+func ForActivity(summary types.Metrics, user types.UserSettings, load func() (powerSeries, cadenceSeries []int, ftp int)) types.Quadrants {
+	if !Stale(summary.Quadrants, user) {
+		return summary.Quadrants
+	}
+	powerSeries, cadenceSeries, ftp := load()
+	return Split(powerSeries, cadenceSeries, user, ftp)
+}
+
+//add one activity's quadrants to a running total
+func Add(total *types.Quadrants, q types.Quadrants) {
+	for i := range q.Seconds {
+		total.Seconds[i] += q.Seconds[i]
+	}
+}
+
+//an empty total around the user's current FTP point
+func Empty(user types.UserSettings) types.Quadrants {
+	return types.Quadrants{Ftp: user.Ftp, Cadence: Cadence(user), CrankLength: CrankLength(user)}
+}
+
+//percentages and the FTP point for display
+func Summarise(q types.Quadrants) (s Summary) {
+	s.Quadrants = q
+	s.FtpCpv = round(Cpv(q.Cadence, q.CrankLength))
+	s.FtpAepf = round(Aepf(q.Ftp, q.Cadence, q.CrankLength))
+	total := 0
+	for _, seconds := range q.Seconds {
+		total += seconds
+	}
+	if total == 0 {
+		return
+	}
+	s.HasData = true
+	for i, seconds := range q.Seconds {
+		s.Percent[i] = round(float64(seconds) / float64(total) * 100)
+	}
+	return
+}
+
+//every second of a ride for the scatter chart (thinned out on long rides)
+func Points(powerSeries, cadenceSeries []int, crankLength float64) []Point {
+	points := make([]Point, 0)
+	step := len(powerSeries)/maxPoints + 1
+	for i := 0; i < len(powerSeries) && i < len(cadenceSeries); i += step {
+		if powerSeries[i] <= 0 || cadenceSeries[i] <= 0 {
+			continue
+		}
+		points = append(points, Point{Cpv: round(Cpv(cadenceSeries[i], crankLength)), Aepf: round(Aepf(powerSeries[i], cadenceSeries[i], crankLength))})
+	}
+	return points
+}
+
+func round(val float64) float64 {
+	return utility.Round(val, .5, 2)
+}
@@ -0,0 +1,260 @@
+/* Personal records. Each ride's best power for a set of durations is compared with the user's earlier rides - all time, season to date and over a rolling 90 days - and any new records are kept in the user_record table against the ride that set them */
+package records
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/jezard/joulepersecond-go/conf"
+	"github.com/jezard/joulepersecond-go/types"
+	"log"
+	"strconv"
+	"time"
+)
+
+//record scopes
+const (
+	AllTime = "all-time"
+	Season  = "season"
+	Rolling = "90-day"
+)
+
+var Scopes = []string{AllTime, Season, Rolling}
+
+//scope names for display
+var ScopeLabels = map[string]string{
+	AllTime: "All time",
+	Season:  "Season",
+	Rolling: "90 days",
+}
+
+//days in the rolling scope
+const RollingDays = 90
+
+//durations records are kept for (seconds)
+var Durations = []int{1, 5, 10, 20, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+var config = conf.Configuration()
+
+//a record for a duration, and the ride it was set on
+type Record struct {
+	Scope         string
+	Seconds       int
+	Label         string //e.g. 5s, 20min
+	Watts         int
+	Previous      int //the record that was beaten, 0 where there wasn't one
+	ActivityId    string
+	ActivityStart time.Time
+}
+
+//a ride's bests
+type Ride struct {
+	ActivityId    string
+	ActivityStart time.Time
+	Bests         []types.MeanMax
+}
+
+//best average power for each duration (from a power series at one sample per second)
+func Bests(powerSeries []int) []types.MeanMax {
+	bests := make([]types.MeanMax, 0)
+	work := make([]int, len(powerSeries)+1)
+	for i, val := range powerSeries {
+		work[i+1] = work[i] + val
+	}
+	for _, secs := range Durations {
+		if secs > len(powerSeries) {
+			break
+		}
+		best := 0
+		for j := secs; j < len(work); j++ {
+			if sum := work[j] - work[j-secs]; sum > best {
+				best = sum
+			}
+		}
+		bests = append(bests, types.MeanMax{Seconds: secs, Watts: best / secs})
+	}
+	return bests
+}
+
+//a duration as a short label
+func Label(seconds int) string {
+	switch {
+	case seconds >= 3600 && seconds%3600 == 0:
+		return strconv.Itoa(seconds/3600) + "h"
+	case seconds >= 60 && seconds%60 == 0:
+		return strconv.Itoa(seconds/60) + "min"
+	}
+	return strconv.Itoa(seconds) + "s"
+}
+
+//when a scope's window opens for a ride (or the records table) at the given time - the season runs from 1 January
+func From(scope string, at time.Time) time.Time {
+	switch scope {
+	case Season:
+		return time.Date(at.Year(), time.January, 1, 0, 0, 0, 0, at.Location())
+	case Rolling:
+		return at.AddDate(0, 0, -RollingDays)
+	}
+	return time.Time{}
+}
+
+//the records a ride sets against the user's earlier rides
+func Compare(ride Ride, history []Ride) []Record {
+	set := make([]Record, 0)
+	for _, scope := range Scopes {
+		from := From(scope, ride.ActivityStart)
+		previous := best(history, from, ride.ActivityStart)
+		for _, b := range ride.Bests {
+			if b.Watts <= 0 {
+				continue
+			}
+			prev := previous[b.Seconds]
+			if b.Watts > prev.Watts {
+				set = append(set, Record{Scope: scope, Seconds: b.Seconds, Label: Label(b.Seconds), Watts: b.Watts, Previous: prev.Watts, ActivityId: ride.ActivityId, ActivityStart: ride.ActivityStart})
+			}
+		}
+	}
+	return set
+}
+
+//the current record for each scope and duration at the given time
+func Current(history []Ride, at time.Time) []Record {
+	current := make([]Record, 0)
+	for _, scope := range Scopes {
+		bests := best(history, From(scope, at), at.Add(time.Second))
+		for _, secs := range Durations {
+			if r, ok := bests[secs]; ok {
+				r.Scope = scope
+				current = append(current, r)
+			}
+		}
+	}
+	return current
+}
+
+//the best ride for each duration from rides starting in [from, to) - the earlier ride keeps a record on a tie
+func best(history []Ride, from, to time.Time) map[int]Record {
+	bests := make(map[int]Record)
+	for _, ride := range history {
+		if ride.ActivityStart.Before(from) || !ride.ActivityStart.Before(to) {
+			continue
+		}
+		for _, b := range ride.Bests {
+			if b.Watts > bests[b.Seconds].Watts {
+				bests[b.Seconds] = Record{Seconds: b.Seconds, Label: Label(b.Seconds), Watts: b.Watts, ActivityId: ride.ActivityId, ActivityStart: ride.ActivityStart}
+			}
+		}
+	}
+	return bests
+}
+
+//the bests of each of the user's rides with power starting on or after from, in date order - rides processed before bests were stored are worked out from their power series
+func History(user types.UserSettings, from time.Time) []Ride {
+	history := make([]Ride, 0)
+
+	var user_data types.Metrics
+	var activity_id string
+	var activity_start time.Time
+	var end_summary_json []byte
+	var has_power bool
+
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	iter := session.Query(`SELECT activity_id, activity_start, end_summary_json, has_power FROM joulepersecond.user_activity WHERE user_id = ? AND activity_start >= ? ORDER BY activity_start ASC`, user.Id, from).Iter()
+	for iter.Scan(&activity_id, &activity_start, &end_summary_json, &has_power) {
+		if !has_power {
+			continue
+		}
+		user_data = types.Metrics{}
+		json.Unmarshal(end_summary_json, &user_data)
+		//rides with estimated power only count if the user wants them to
+		if user_data.EstimatedPower && !user.UseEstimated {
+			continue
+		}
+		bests := user_data.Bests
+		if len(bests) == 0 {
+			var power_json []byte
+			var power_series []int
+			session.Query(`SELECT power_json FROM joulepersecond.proc_activity WHERE activity_id = ? `, activity_id).Scan(&power_json)
+			json.Unmarshal(power_json, &power_series)
+			bests = Bests(power_series)
+		}
+		history = append(history, Ride{ActivityId: activity_id, ActivityStart: activity_start, Bests: bests})
+	}
+	if err := iter.Close(); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	return history
+}
+
+//the rides that can hold a record at a time - every ride before it that set one (from the user_record table, so the
+//whole history isn't scanned on each upload) and every ride in the rolling window, which moves on past older records.
+//Users with no stored records yet get their whole history
+func Holders(user types.UserSettings, at time.Time) []Ride {
+	holders := make([]Ride, 0)
+	var r Record
+
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	iter := session.Query(`SELECT activity_start, seconds, activity_id, watts FROM joulepersecond.user_record WHERE user_id = ? AND activity_start < ?`, user.Id, at).Iter()
+	for iter.Scan(&r.ActivityStart, &r.Seconds, &r.ActivityId, &r.Watts) {
+		holders = append(holders, Ride{ActivityId: r.ActivityId, ActivityStart: r.ActivityStart, Bests: []types.MeanMax{{Seconds: r.Seconds, Watts: r.Watts}}})
+	}
+	if err := iter.Close(); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	if len(holders) == 0 {
+		return History(user, time.Unix(0, 0))
+	}
+	return append(holders, History(user, From(Rolling, at))...)
+}
+
+//replace the records stored for a ride
+func Save(user types.UserSettings, activityStart time.Time, set []Record) {
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	//clear out any records from an earlier processing of the activity
+	if err := session.Query(`DELETE FROM user_record WHERE user_id = ? AND activity_start = ?`, user.Id, activityStart).Exec(); err != nil {
+		log.Printf("Location:%v", err)
+	}
+	for _, r := range set {
+		if err := session.Query(`INSERT INTO user_record (user_id, activity_start, scope, seconds, activity_id, watts, previous) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			user.Id, activityStart, r.Scope, r.Seconds, r.ActivityId, r.Watts, r.Previous).Exec(); err != nil {
+			log.Printf("Location:%v", err)
+		}
+	}
+}
+
+//the records set by rides starting in [from, to]
+func Set(user types.UserSettings, from, to time.Time) []Record {
+	set := make([]Record, 0)
+	var r Record
+
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	iter := session.Query(`SELECT activity_start, scope, seconds, activity_id, watts, previous FROM joulepersecond.user_record WHERE user_id = ? AND activity_start >= ? AND activity_start <= ?`, user.Id, from, to).Iter()
+	for iter.Scan(&r.ActivityStart, &r.Scope, &r.Seconds, &r.ActivityId, &r.Watts, &r.Previous) {
+		r.Label = Label(r.Seconds)
+		set = append(set, r)
+	}
+	if err := iter.Close(); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	return set
+}
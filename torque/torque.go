@@ -0,0 +1,111 @@
+/* Torque and cadence choice. Torque is worked out each second from power and cadence, and the seconds spent pedalling are grouped by the user's power zones to show the cadence (and torque) they choose at each intensity */
+package torque
+
+import (
+	"github.com/jezard/joulepersecond-go/types"
+	"github.com/jezard/joulepersecond-go/utility"
+	"github.com/jezard/joulepersecond-go/zones"
+	"math"
+)
+
+//torque (Nm) for a second of power and cadence - zero when not pedalling
+func Torque(power, cadence int) float64 {
+	if power <= 0 || cadence <= 0 {
+		return 0
+	}
+	return float64(power) / (float64(cadence) * 2 * math.Pi / 60)
+}
+
+//torque each second
+func Series(powerSeries, cadenceSeries []int) []float64 {
+	series := make([]float64, 0)
+	for i := 0; i < len(powerSeries) && i < len(cadenceSeries); i++ {
+		series = append(series, utility.Round(Torque(powerSeries[i], cadenceSeries[i]), .5, 1))
+	}
+	return series
+}
+
+//average torque while pedalling and max torque
+func Summary(powerSeries, cadenceSeries []int) (average, max float64) {
+	var sum float64
+	var count int
+	for i := 0; i < len(powerSeries) && i < len(cadenceSeries); i++ {
+		torque := Torque(powerSeries[i], cadenceSeries[i])
+		if torque == 0 {
+			continue
+		}
+		sum += torque
+		count++
+		if torque > max {
+			max = torque
+		}
+	}
+	if count > 0 {
+		average = sum / float64(count)
+	}
+	return utility.Round(average, .5, 1), utility.Round(max, .5, 1)
+}
+
+//average cadence and torque in each of the user's power zones (at the given FTP) - only seconds spent pedalling count
+func Profile(powerSeries, cadenceSeries []int, user types.UserSettings, ftp int) (profile types.CadenceProfile) {
+	powerZones := zones.Power(user, ftp)
+	profile.PowerModel = powerZones.Model
+	profile.PowerBounds = powerZones.Bounds
+	profile.Bands = make([]types.PowerBand, len(powerZones.Zones))
+	cadenceSums := make([]int, len(profile.Bands))
+	torqueSums := make([]float64, len(profile.Bands))
+	for i := 0; i < len(powerSeries) && i < len(cadenceSeries); i++ {
+		if powerSeries[i] <= 0 || cadenceSeries[i] <= 0 {
+			continue
+		}
+		z := powerZones.Index(float64(powerSeries[i]))
+		profile.Bands[z].Seconds++
+		cadenceSums[z] += cadenceSeries[i]
+		torqueSums[z] += Torque(powerSeries[i], cadenceSeries[i])
+	}
+	for z, band := range profile.Bands {
+		if band.Seconds > 0 {
+			profile.Bands[z].Cadence = cadenceSums[z] / band.Seconds
+			profile.Bands[z].Torque = utility.Round(torqueSums[z]/float64(band.Seconds), .5, 1)
+		}
+	}
+	return
+}
+
+//whether a stored profile was grouped by other power zones (model or boundaries) than the user's current ones
+func Stale(profile types.CadenceProfile, user types.UserSettings) bool {
+	powerZones := zones.Power(user, user.Ftp)
+	return profile.PowerModel != powerZones.Model || profile.PowerBounds != powerZones.Bounds || len(profile.Bands) != len(powerZones.Zones)
+}
+
+//an activity's stored profile, only grouping the time series (fetched by load) again where the user's power zones have since changed
+func ForActivity(summary types.Metrics, user types.UserSettings, load func() (powerSeries, cadenceSeries []int, ftp int)) types.CadenceProfile {
+	if !Stale(summary.CadenceProfile, user) {
+		return summary.CadenceProfile
+	}
+	powerSeries, cadenceSeries, ftp := load()
+	return Profile(powerSeries, cadenceSeries, user, ftp)
+}
+
+//an empty total for the user's current power zones
+func Empty(user types.UserSettings) (profile types.CadenceProfile) {
+	powerZones := zones.Power(user, user.Ftp)
+	profile.PowerModel = powerZones.Model
+	profile.PowerBounds = powerZones.Bounds
+	profile.Bands = make([]types.PowerBand, len(powerZones.Zones))
+	return
+}
+
+//add one activity's profile to a running total, averages are weighted by the time in each zone
+func Add(total *types.CadenceProfile, profile types.CadenceProfile) {
+	for z, band := range profile.Bands {
+		if z >= len(total.Bands) || band.Seconds == 0 {
+			continue
+		}
+		sum := total.Bands[z]
+		seconds := sum.Seconds + band.Seconds
+		total.Bands[z].Cadence = (sum.Cadence*sum.Seconds + band.Cadence*band.Seconds) / seconds
+		total.Bands[z].Torque = utility.Round((sum.Torque*float64(sum.Seconds)+band.Torque*float64(band.Seconds))/float64(seconds), .5, 1)
+		total.Bands[z].Seconds = seconds
+	}
+}
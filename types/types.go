@@ -22,7 +22,7 @@ type UserSettings struct {
 	Theme         string
 	Demo          bool           //is this a demo?
 	TimeOffset    int            //view dashboard history from a previous day - useful for testing if nothing else
-	SampleSize    int            //might use this to adjust user selected sample size
+	SampleSize    int            //seconds power is averaged over before being split into zones (default 5)
 	Ftp           int            //user's Functional Threshold Power
 	Thr           int            //User's functional threshold Heartrate
 	Ncp_rolloff   int            //User set Notable Critical Power performance rolloff constant
@@ -34,6 +34,42 @@ type UserSettings struct {
 	Weight        int            //user's weight
 	Age           int            //user's age
 	StandardRides []StandardRide //user's standard rides
+	LoadMetric    string         //training load metric driving the fitness/freshness and load charts (default 'tss')
+	Cleaning      CleaningRules  //data quality rules applied when processing an activity
+	Mhr           int            //user's maximum heart rate (estimated when not set)
+	PowerZones    ZoneModel      //user's chosen power zone model
+	HeartZones    ZoneModel      //user's chosen heart rate zone model
+	Bins          HistogramBins  //histogram bin widths
+	CrankLength   float64        //user's crank length in mm (default 172.5)
+	FtpCadence    int            //cadence of the FTP point the pedalling quadrants are split around (default 90)
+	BikeWeight    float64        //kg (default 8)
+	CdA, Crr      float64        //user's drag area (m^2) and rolling resistance for estimating power
+	UseEstimated  bool           //whether power estimated from speed counts towards NP, TSS and the power curve
+	DurabilityKj  []int          //work done (kJ) before the fatigued power curves start (default 1000, 2000, 3000)
+	AtlSeed       float64        //ATL going into the first day of the fitness/freshness series
+	CtlSeed       float64        //CTL going into the first day of the fitness/freshness series
+	SeedDate      time.Time      //day the seeds apply from, earlier activities being counted in them (zero to start at the first activity)
+	Location      *time.Location //user's time zone, for bucketing activities into calendar days, weeks and months (default server local)
+	WeekStart     time.Weekday   //first day of the user's training week (default Monday)
+}
+
+//bin widths for the power, heart rate and cadence histograms - zero values take the defaults (see the histogram package)
+type HistogramBins struct {
+	Power, Heart, Cadence int //watts, bpm and rpm
+}
+
+//a zone model chosen by the user (see the zones package)
+type ZoneModel struct {
+	Model  string    //model name e.g. 'coggan', 'polarized', 'custom'
+	Custom []float64 //custom models only: the upper boundary of each zone (bar the last) as a percentage of threshold
+}
+
+//rules for the data quality (cleaning) stage of activity processing - zero values take the defaults
+type CleaningRules struct {
+	Enabled                                              bool
+	SpikePercentile                                      float64 //percentile of the ride's power used as the spike reference
+	SpikeFactor                                          float64 //power beyond this multiple of the reference is a spike
+	HrDropoutSeconds, HrStuckSeconds, CadenceLockSeconds int     //longest heart rate gap filled, and how long hr or cadence may be 'stuck' before flagging
 }
 
 type CPMs struct {
@@ -54,15 +90,34 @@ type Tvd_data_point struct {
 	Dur  time.Duration
 }
 
+//a single training zone - Low is 0 for the bottom zone and High is 0 for the top zone
+type Zone struct {
+	Name, Description string
+	Low, High         int
+}
+
 type ZoneLabels struct {
-	PowerZ1, PowerZ2, PowerZ3, PowerZ4, PowerZ5, PowerZ6, HeartZ1, HeartZ2, HeartZ3, HeartZ4, HeartZ5a, HeartZ5b, HeartZ5c int
+	PowerModel, HeartModel string //model names
+	Power, Heart           []Zone
 }
 
 type Metrics struct {
 	Avpower, Avheart, Avcad, Np, Tss, Etss, Utss, WorkDone, EnergyUsedKc, EnergyUsedKj, IfHr int //Utss will be used to store a user's overidden tss  [probably best to store these overrides in a seperate table]
+	MaxPower, MaxHeart, MaxCad, XPower                                                       int
 	If                                                                                       float64
+	Wkg                                                                                      float64 //average watts per kilo
+	Vi, Ef, Decoupling                                                                       float64 //variability index, efficiency factor and Pw:HR decoupling (percent)
 	StartTime                                                                                time.Time
 	Dur                                                                                      time.Duration
+	Load                                                                                     map[string]int //training load from each of the load metrics (see loadmetric)
+	TimeInZone                                                                               Zones          //worked out once when the activity is processed
+	Histograms                                                                               Histograms     //also worked out when the activity is processed
+	Quadrants                                                                                Quadrants      //and pedalling quadrants
+	Torque, MaxTorque                                                                        float64        //average (while pedalling) and max torque in Nm
+	CadenceProfile                                                                           CadenceProfile //cadence and torque in each power zone
+	EstimatedPower                                                                           bool           //power was estimated from speed and gradient (no power meter)
+	Durability                                                                               []DurabilityCurve
+	Bests                                                                                    []MeanMax //best power for each of the records durations
 }
 type Current_ff struct {
 	Ctl, Atl, Tsb int
@@ -72,6 +127,7 @@ type Current_ff struct {
 type ActivityMeta struct {
 	ActivityName, ActivityID                      string
 	TssOverride, MotivationLevel, PerceivedEffort int
+	SessionRpe                                    int //rating of perceived exertion for the whole session (CR-10 scale)
 	IndoorRide, OutdoorRide, Race, Train          bool
 }
 
@@ -82,21 +138,69 @@ type Ff_data_point struct {
 	Ctl              float64
 	Atl              float64
 	Tsb              float64
+	RpeLoad          int     //session RPE load (RPE x minutes)
+	RpeCtl           float64 //fitness from session RPE load
+	RpeAtl           float64 //fatigue from session RPE load
+	RpeTsb           float64 //form from session RPE load
+	RampRate         float64 //CTL gained over the last week
+	Monotony         float64 //Foster's training monotony over the last week
+	Strain           int     //Foster's training strain over the last week
+	Acwr             float64 //acute:chronic workload ratio (7:28 day rolling averages)
 	NotableCp        float64
 	HasValue         bool
+	Forecast         bool //projected from planned load (after today)
 	Day, Month, Year int
 	Meta             ActivityMeta
 }
 
-/* Power Zones:
-Zone 1 Less than 55% of FTPw
-Zone 2 55% to 74% of FTPw
-Zone 3 75% to 89% of FTPw
-Zone 4 90% to 104% of FTPw
-Zone 5 105% to 120% of FTPw
-Zone 6 More than 120% of FTPw
-*/
+//time (seconds) in each of the user's power and heart rate zones, in the same order as the ZoneLabels
 type Zones struct {
-	Z1, Z2, Z3, Z4, Z5, Z6, HR1, HR2, HR3, HR4, HR5a, HR5b, HR5c int
-	HasPower, HasHeart                                           bool
+	Power, Heart           []int
+	HasPower, HasHeart     bool
+	PowerModel, HeartModel string //zone models the time was split by
+	PowerBounds            string //and their boundaries (see zones.Set)
+	HeartBounds            string
+	Smoothing              int //seconds power was averaged over before being split into zones
+}
+
+//seconds spent at each value of a series, grouped into bins of Width - Counts[i] holds the time from i*Width up to (i+1)*Width
+type Histogram struct {
+	Width  int
+	Counts []int
+}
+
+type Histograms struct {
+	Power, Heart, Cadence Histogram
+}
+
+//seconds pedalled in each quadrant of average effective pedal force vs circumferential pedal velocity, split around the FTP point (see the quadrant package)
+type Quadrants struct {
+	Ftp, Cadence int     //the FTP point
+	CrankLength  float64 //mm
+	Seconds      [4]int  //I high force/high velocity, II high force/low velocity, III low force/low velocity, IV low force/high velocity
+}
+
+//cadence and torque while pedalling in one of the user's power zones
+type PowerBand struct {
+	Seconds int
+	Cadence int     //average cadence
+	Torque  float64 //average torque (Nm)
+}
+
+//the cadence chosen in each power zone (see the torque package)
+type CadenceProfile struct {
+	PowerModel  string      //zone model the bands are from
+	PowerBounds string      //and its boundaries (see zones.Set)
+	Bands       []PowerBand //one per power zone, in the same order as the ZoneLabels
+}
+
+// best power for a duration
+type MeanMax struct {
+	Seconds, Watts int
+}
+
+// mean maximal power from the part of a ride after Kj of work (0 is the whole ride - see the durability package)
+type DurabilityCurve struct {
+	Kj     int
+	Points []MeanMax
 }
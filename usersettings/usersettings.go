@@ -6,8 +6,12 @@ import (
 	//"fmt"
 	_ "github.com/go-sql-driver/mysql" //go get github.com/go-sql-driver/mysql
 	"github.com/jezard/joulepersecond-go/conf"
+	"github.com/jezard/joulepersecond-go/dataquality"
+	"github.com/jezard/joulepersecond-go/loadmetric"
 	"github.com/jezard/joulepersecond-go/types"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -32,10 +36,25 @@ func Get(enc_uid string) (user types.UserSettings, _err error) {
 	var my_ftp, my_thr, my_rhr, my_weight, set_ncp_rolloff, my_age, set_data_cutoff, id int
 	var set_autofill, my_gender, ride_label string
 	var my_vo2 float32
+	//settings columns added since are nullable (NULL where the user hasn't set them)
+	var set_load_metric sql.NullString
+	var set_clean_data sql.NullBool
+	var set_spike_percentile, set_spike_factor sql.NullFloat64
+	var set_hr_dropout, set_hr_stuck, set_cad_lock sql.NullInt64
+	var my_mhr, set_zone_smoothing sql.NullInt64
+	var set_power_bin, set_heart_bin, set_cad_bin, set_ftp_cadence sql.NullInt64
+	var my_crank_length, my_bike_weight, my_cda, my_crr sql.NullFloat64
+	var set_use_estimated sql.NullBool
+	var set_durability_kj sql.NullString
+	var set_atl_days, set_ctl_days sql.NullInt64
+	var set_atl_seed, set_ctl_seed sql.NullFloat64
+	var set_seed_date sql.NullString
+	var set_timezone, set_week_start sql.NullString
+	var set_power_zones, set_heart_zones, set_power_bounds, set_heart_bounds sql.NullString
 	var standard_ride types.StandardRide
 	var standard_rides []types.StandardRide
 
-	err = db.QueryRow("SELECT paid_account, my_ftp, my_thr, my_rhr, my_weight, set_ncp_rolloff, set_autofill, set_data_cutoff, my_age, my_vo2, my_gender FROM user WHERE email=?", uid).Scan(
+	err = db.QueryRow("SELECT paid_account, my_ftp, my_thr, my_rhr, my_weight, set_ncp_rolloff, set_autofill, set_data_cutoff, my_age, my_vo2, my_gender, set_load_metric, set_clean_data, set_spike_percentile, set_spike_factor, set_hr_dropout, set_hr_stuck, set_cad_lock, my_mhr, set_power_zones, set_heart_zones, set_power_bounds, set_heart_bounds, set_zone_smoothing, set_power_bin, set_heart_bin, set_cad_bin, my_crank_length, set_ftp_cadence, my_bike_weight, my_cda, my_crr, set_use_estimated, set_durability_kj, set_atl_days, set_ctl_days, set_atl_seed, set_ctl_seed, set_seed_date, set_timezone, set_week_start FROM user WHERE email=?", uid).Scan(
 		&paid_account,
 		&my_ftp,
 		&my_thr,
@@ -47,6 +66,36 @@ func Get(enc_uid string) (user types.UserSettings, _err error) {
 		&my_age,
 		&my_vo2,
 		&my_gender,
+		&set_load_metric,
+		&set_clean_data,
+		&set_spike_percentile,
+		&set_spike_factor,
+		&set_hr_dropout,
+		&set_hr_stuck,
+		&set_cad_lock,
+		&my_mhr,
+		&set_power_zones,
+		&set_heart_zones,
+		&set_power_bounds,
+		&set_heart_bounds,
+		&set_zone_smoothing,
+		&set_power_bin,
+		&set_heart_bin,
+		&set_cad_bin,
+		&my_crank_length,
+		&set_ftp_cadence,
+		&my_bike_weight,
+		&my_cda,
+		&my_crr,
+		&set_use_estimated,
+		&set_durability_kj,
+		&set_atl_days,
+		&set_ctl_days,
+		&set_atl_seed,
+		&set_ctl_seed,
+		&set_seed_date,
+		&set_timezone,
+		&set_week_start,
 	)
 
 	if err != nil {
@@ -79,13 +128,78 @@ func Get(enc_uid string) (user types.UserSettings, _err error) {
 	user.Vo2 = my_vo2
 	user.Gender = my_gender
 	user.StandardRides = standard_rides
+	user.LoadMetric = set_load_metric.String
+	if _, ok := loadmetric.Labels[user.LoadMetric]; !ok { //not set, or no longer one of the metrics
+		user.LoadMetric = loadmetric.Tss
+	}
+	user.Cleaning.Enabled = dataquality.Defaults.Enabled //on unless the user has turned it off
+	if set_clean_data.Valid {
+		user.Cleaning.Enabled = set_clean_data.Bool
+	}
+	user.Cleaning.SpikePercentile = set_spike_percentile.Float64
+	user.Cleaning.SpikeFactor = set_spike_factor.Float64
+	user.Cleaning.HrDropoutSeconds = int(set_hr_dropout.Int64)
+	user.Cleaning.HrStuckSeconds = int(set_hr_stuck.Int64)
+	user.Cleaning.CadenceLockSeconds = int(set_cad_lock.Int64)
+	user.Mhr = int(my_mhr.Int64)
+	user.PowerZones = types.ZoneModel{Model: set_power_zones.String, Custom: percentages(set_power_bounds.String)}
+	user.HeartZones = types.ZoneModel{Model: set_heart_zones.String, Custom: percentages(set_heart_bounds.String)}
+	user.SampleSize = int(set_zone_smoothing.Int64)
+	if user.SampleSize < 1 {
+		user.SampleSize = 5
+	}
+	user.Bins = types.HistogramBins{Power: int(set_power_bin.Int64), Heart: int(set_heart_bin.Int64), Cadence: int(set_cad_bin.Int64)}
+	user.CrankLength = my_crank_length.Float64
+	user.FtpCadence = int(set_ftp_cadence.Int64)
+	user.BikeWeight = my_bike_weight.Float64
+	user.CdA = my_cda.Float64
+	user.Crr = my_crr.Float64
+	user.UseEstimated = set_use_estimated.Bool
+	for _, kj := range percentages(set_durability_kj.String) { //same format, a sorted list of positive numbers
+		user.DurabilityKj = append(user.DurabilityKj, int(kj))
+	}
+	user.Atl_constant = int(set_atl_days.Int64)
+	if user.Atl_constant < 1 {
+		user.Atl_constant = 7
+	}
+	user.Ctl_constant = int(set_ctl_days.Int64)
+	if user.Ctl_constant < 1 {
+		user.Ctl_constant = 42
+	}
+	user.AtlSeed = set_atl_seed.Float64
+	user.CtlSeed = set_ctl_seed.Float64
+	user.Location = time.Local
+	if set_timezone.String != "" {
+		if loc, err := time.LoadLocation(set_timezone.String); err == nil { //IANA name e.g. Europe/London
+			user.Location = loc
+		}
+	}
+	seedDate, err := time.ParseInLocation("2006-01-02", set_seed_date.String, user.Location) //MySQL DATE, a day in the user's time zone
+	if err == nil {
+		user.SeedDate = seedDate
+	}
+	user.WeekStart = time.Monday
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(set_week_start.String, d.String()) {
+			user.WeekStart = d
+		}
+	}
 
 	//hardcoded (for now) settings
-	user.Atl_constant = 7
-	user.Ctl_constant = 42
-	user.SampleSize = 5
 	user.TimeOffset = 0 //eg 0, -1, -2 etc... or 7 go forward a week
 
 	return
 
 }
+
+//parse a comma separated list of percentages e.g. "55,75,90,105"
+func percentages(list string) (vals []float64) {
+	for _, str := range strings.Split(list, ",") {
+		val, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+		if err == nil && val > 0 {
+			vals = append(vals, val)
+		}
+	}
+	sort.Float64s(vals)
+	return
+}
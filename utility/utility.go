@@ -1,8 +1,10 @@
 package utility
 
 import (
+	"encoding/json"
 	"github.com/jezard/joulepersecond-go/conf"
 	"math"
+	"net/http"
 )
 
 func Dostuff() string {
@@ -25,3 +27,14 @@ func Round(val float64, roundOn float64, places int) (newVal float64) {
 	newVal = round / pow
 	return
 }
+
+//write a value out as a JSON response
+func WriteJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
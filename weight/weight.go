@@ -0,0 +1,242 @@
+/* Weight log. Dated body weight (and optional body fat) entries kept in the user_weight table, so rides are worked out with the weight in force on the day rather than the current settings value */
+package weight
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/jezard/joulepersecond-go/conf"
+	"github.com/jezard/joulepersecond-go/types"
+	"github.com/jezard/joulepersecond-go/usersettings"
+	"github.com/jezard/joulepersecond-go/utility"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//date format for the API and imports
+const DateFormat = "2006-01-02"
+
+var config = conf.Configuration()
+
+//a weigh-in
+type Entry struct {
+	Date    time.Time
+	Weight  float64 //kg
+	BodyFat float64 //percent, 0 where not recorded
+}
+
+//sort entries by date
+type ByDate []Entry
+
+func (a ByDate) Len() int           { return len(a) }
+func (a ByDate) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByDate) Less(i, j int) bool { return a[i].Date.Before(a[j].Date) }
+
+//the weight in force on a date - the last weigh-in on or before it, or the first weigh-in for dates before the log starts. 0 for an empty log
+func On(entries []Entry, date time.Time) float64 {
+	if len(entries) == 0 {
+		return 0
+	}
+	w := entries[0].Weight
+	for _, entry := range entries {
+		if entry.Date.After(date) {
+			break
+		}
+		w = entry.Weight
+	}
+	return w
+}
+
+//the weight on a date from the user's log, their settings weight where the log is empty
+func ForDate(user types.UserSettings, date time.Time) float64 {
+	if w := On(Log(user, time.Time{}, time.Time{}), date); w > 0 {
+		return w
+	}
+	return float64(user.Weight)
+}
+
+//the user's weigh-ins in date order between from and to (zero times leave the range open)
+func Log(user types.UserSettings, from, to time.Time) []Entry {
+	entries := make([]Entry, 0)
+	var entry Entry
+
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	if to.IsZero() {
+		to = time.Now().AddDate(100, 0, 0)
+	}
+	iter := session.Query(`SELECT weight_date, weight, body_fat FROM joulepersecond.user_weight WHERE user_id = ? AND weight_date >= ? AND weight_date <= ? ORDER BY weight_date ASC`, user.Id, from, to).Iter()
+	for iter.Scan(&entry.Date, &entry.Weight, &entry.BodyFat) {
+		entries = append(entries, entry)
+	}
+	if err := iter.Close(); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	return entries
+}
+
+//add or replace weigh-ins (one per day)
+func Save(user types.UserSettings, entries []Entry) {
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	for _, entry := range entries {
+		if err := session.Query(`INSERT INTO user_weight (user_id, weight_date, weight, body_fat) VALUES (?, ?, ?, ?)`,
+			user.Id, entry.Date, entry.Weight, entry.BodyFat).Exec(); err != nil {
+			log.Printf("Location:%v", err)
+		}
+	}
+}
+
+//remove the weigh-in on a date
+func Delete(user types.UserSettings, date time.Time) {
+	cluster := gocql.NewCluster(config.DbHost)
+	cluster.Keyspace = "joulepersecond"
+	cluster.Consistency = gocql.Quorum
+	session, _ := cluster.CreateSession()
+	defer session.Close()
+
+	if err := session.Query(`DELETE FROM user_weight WHERE user_id = ? AND weight_date = ?`, user.Id, date).Exec(); err != nil {
+		log.Printf("Location:%v", err)
+	}
+}
+
+//a weigh-in from its date, weight and body fat values
+func Parse(date, weight, bodyFat string) (entry Entry, err error) {
+	entry.Date, err = time.Parse(DateFormat, strings.TrimSpace(date))
+	if err != nil {
+		return entry, errors.New("Date must be in the format " + DateFormat)
+	}
+	entry.Weight, err = strconv.ParseFloat(strings.TrimSpace(weight), 64)
+	if err != nil || entry.Weight <= 0 {
+		return entry, errors.New("Weight must be a positive number (kg)")
+	}
+	if bodyFat = strings.TrimSpace(bodyFat); bodyFat != "" {
+		entry.BodyFat, err = strconv.ParseFloat(bodyFat, 64)
+		if err != nil || entry.BodyFat < 0 || entry.BodyFat >= 100 {
+			return entry, errors.New("Body fat must be a percentage")
+		}
+	}
+	return entry, nil
+}
+
+//weigh-ins from CSV (date, weight[, body fat]) - a header row is skipped
+func Import(r io.Reader) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return entries, err
+	}
+	for i, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		bodyFat := ""
+		if len(record) > 2 {
+			bodyFat = record[2]
+		}
+		entry, err := Parse(record[0], record[1], bodyFat)
+		if err != nil {
+			if i == 0 { //header
+				continue
+			}
+			return entries, errors.New("Line " + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+		entries = append(entries, entry)
+	}
+	sort.Sort(ByDate(entries))
+	return entries, nil
+}
+
+//the weight log API
+//
+//	GET /weight/<token>?from=&to= lists weigh-ins
+//	POST /weight/<token> with date, weight and body-fat adds (or replaces) one
+//	DELETE /weight/<token>?date= removes one
+//	POST /weight/import/<token> with a CSV file (file) or body adds many
+func WeightHandler(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token")
+
+	urlparts := strings.Split(r.URL.Path[1:], "/")
+	if len(urlparts) < 2 || urlparts[len(urlparts)-1] == "" {
+		http.Error(w, "Malformed URL", http.StatusBadRequest)
+		return
+	}
+	importing := len(urlparts) > 2 && urlparts[1] == "import"
+	token := urlparts[len(urlparts)-1]
+	access_token, err := url.QueryUnescape(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := Usersettings.Get(access_token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" && user.Demo {
+		http.Error(w, "Forbidden: Cannot complete this task.", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case importing:
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body io.Reader = r.Body
+		if file, _, err := r.FormFile("file"); err == nil {
+			defer file.Close()
+			body = file
+		}
+		entries, err := Import(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		Save(user, entries)
+		utility.WriteJSON(w, entries)
+	case r.Method == "POST":
+		entry, err := Parse(r.FormValue("date"), r.FormValue("weight"), r.FormValue("body-fat"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		Save(user, []Entry{entry})
+		utility.WriteJSON(w, entry)
+	case r.Method == "DELETE":
+		date, err := time.Parse(DateFormat, r.FormValue("date"))
+		if err != nil {
+			http.Error(w, "Date must be in the format "+DateFormat, http.StatusBadRequest)
+			return
+		}
+		Delete(user, date)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		from, _ := time.Parse(DateFormat, r.FormValue("from"))
+		to, _ := time.Parse(DateFormat, r.FormValue("to"))
+		utility.WriteJSON(w, Log(user, from, to))
+	}
+}
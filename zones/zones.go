@@ -0,0 +1,326 @@
+/* Training zone models. Each model splits power or heart rate into zones from the user's threshold values, the dashboard, activity and analysis views all read their zones (and zone labels) from here */
+package zones
+
+import (
+	"fmt"
+	"github.com/jezard/joulepersecond-go/types"
+	"strconv"
+)
+
+//names of the available models (as stored in the user's settings)
+const (
+	Coggan    = "coggan"    //power: Coggan's 7 zones as a percentage of FTP (Z7 neuromuscular)
+	Lthr      = "lthr"      //heart rate: 7 zones (1-4, 5a, 5b, 5c) as a percentage of threshold heart rate
+	Polarized = "polarized" //power or heart rate: 3 zones split at the first and second thresholds
+	HrMax     = "hrmax"     //heart rate: 5 zones as a percentage of max heart rate
+	Karvonen  = "karvonen"  //heart rate: 5 zones as a percentage of heart rate reserve (uses resting heart rate)
+	Custom    = "custom"    //power or heart rate: user defined boundaries as a percentage of FTP or threshold heart rate
+)
+
+//the default models
+const (
+	DefaultPower = Coggan
+	DefaultHeart = Lthr
+)
+
+//the reference value a model's boundaries are a fraction of
+const (
+	threshold = iota //FTP or threshold heart rate
+	maximum          //max heart rate
+	reserve          //heart rate reserve (max - resting)
+)
+
+//a zone model
+type Model struct {
+	Label        string
+	Names        []string  //zone names, lowest first
+	Descriptions []string  //optional
+	Bounds       []float64 //upper boundary of each zone (bar the last) as a fraction of the reference value
+	PowerBounds  []float64 //optional, where power zones split at different fractions of FTP
+	Power, Heart bool      //whether the model can be used for power and/or heart rate
+	reference    int
+}
+
+var Models = map[string]Model{
+	Coggan: {
+		Label:        "Coggan (7 zone)",
+		Names:        []string{"Zone 1", "Zone 2", "Zone 3", "Zone 4", "Zone 5", "Zone 6", "Zone 7"},
+		Descriptions: []string{"Active recovery", "Endurance", "Tempo", "Lactate threshold", "VO2 max", "Anaerobic capacity", "Neuromuscular power"},
+		Bounds:       []float64{0.55, 0.74, 0.89, 1.04, 1.2, 1.5},
+		Power:        true,
+	},
+	Lthr: {
+		Label:        "Threshold heart rate (7 zone)",
+		Names:        []string{"Zone 1", "Zone 2", "Zone 3", "Zone 4", "Zone 5a", "Zone 5b", "Zone 5c"},
+		Descriptions: []string{"Recovery", "Aerobic", "Tempo", "Sub threshold", "Super threshold", "Aerobic capacity", "Anaerobic capacity"},
+		Bounds:       []float64{0.81, 0.89, 0.93, 0.99, 1.02, 1.06},
+		Heart:        true,
+	},
+	Polarized: {
+		Label:        "Polarized (3 zone)",
+		Names:        []string{"Zone 1", "Zone 2", "Zone 3"},
+		Descriptions: []string{"Below first threshold", "Between thresholds", "Above second threshold"},
+		Bounds:       []float64{0.89, 1},
+		PowerBounds:  []float64{0.75, 1},
+		Power:        true,
+		Heart:        true,
+	},
+	HrMax: {
+		Label:        "% max heart rate (5 zone)",
+		Names:        []string{"Zone 1", "Zone 2", "Zone 3", "Zone 4", "Zone 5"},
+		Descriptions: []string{"Very light", "Light", "Moderate", "Hard", "Maximum"},
+		Bounds:       []float64{0.6, 0.7, 0.8, 0.9},
+		Heart:        true,
+		reference:    maximum,
+	},
+	Karvonen: {
+		Label:        "Karvonen heart rate reserve (5 zone)",
+		Names:        []string{"Zone 1", "Zone 2", "Zone 3", "Zone 4", "Zone 5"},
+		Descriptions: []string{"Very light", "Light", "Moderate", "Hard", "Maximum"},
+		Bounds:       []float64{0.6, 0.7, 0.8, 0.9},
+		Heart:        true,
+		reference:    reserve,
+	},
+	Custom: {
+		Label: "Custom",
+		Power: true,
+		Heart: true,
+	},
+}
+
+//a zone model resolved to absolute values for a rider
+type Set struct {
+	Model  string
+	Bounds string //the boundaries the zones were worked out from bar the activity's own threshold - stored zones from other boundaries are stale
+	Zones  []types.Zone
+	upper  []float64 //absolute upper boundary of each zone (bar the last)
+}
+
+//the user's power zones for an activity ridden at the given FTP
+func Power(user types.UserSettings, ftp int) Set {
+	name, model := choose(user.PowerZones, DefaultPower, true)
+	s := resolve(name, model, float64(ftp), 0)
+	s.Bounds = bounds(model, 0, 0)
+	return s
+}
+
+//the user's heart rate zones for an activity ridden at the given threshold heart rate
+func Heart(user types.UserSettings, thr int) Set {
+	name, model := choose(user.HeartZones, DefaultHeart, false)
+	var ref, rest float64
+	switch model.reference {
+	case maximum:
+		ref = float64(MaxHr(user))
+	case reserve:
+		ref = float64(MaxHr(user))
+		rest = float64(user.Rhr)
+	default:
+		s := resolve(name, model, float64(thr), 0)
+		s.Bounds = bounds(model, 0, 0)
+		return s
+	}
+	s := resolve(name, model, ref, rest)
+	s.Bounds = bounds(model, ref, rest) //max and resting heart rate are the user's current ones, not the activity's
+	return s
+}
+
+//threshold heart rate zones regardless of the user's choice (estimated TSS is weighted by these)
+func ThresholdHeart(thr int) Set {
+	return resolve(Lthr, Models[Lthr], float64(thr), 0)
+}
+
+//the user's current power and heart rate zones for display
+func Labels(user types.UserSettings) (labels types.ZoneLabels) {
+	power := Power(user, user.Ftp)
+	heart := Heart(user, user.Thr)
+	labels.PowerModel = Models[power.Model].Label
+	labels.HeartModel = Models[heart.Model].Label
+	labels.Power = power.Zones
+	labels.Heart = heart.Zones
+	return
+}
+
+//time (seconds) in each of the user's power and heart rate zones for a stretch of the time series - power is smoothed over the user's window first
+func TimeInZone(powerSeries, heartSeries []int, user types.UserSettings, ftp, thr int) (zoneData types.Zones) {
+	powerZones := Power(user, ftp)
+	heartZones := Heart(user, thr)
+	zoneData.PowerModel = powerZones.Model
+	zoneData.HeartModel = heartZones.Model
+	zoneData.PowerBounds = powerZones.Bounds
+	zoneData.HeartBounds = heartZones.Bounds
+	zoneData.Smoothing = Smoothing(user)
+	zoneData.Power = powerZones.Counts()
+	zoneData.Heart = heartZones.Counts()
+
+	for _, val := range powerSeries {
+		if val > 0 {
+			zoneData.HasPower = true
+			break
+		}
+	}
+	if zoneData.HasPower {
+		for _, average := range Smooth(powerSeries, zoneData.Smoothing) {
+			zoneData.Power[powerZones.Index(average)]++
+		}
+	}
+	for _, val := range heartSeries {
+		if val > 0 { //no hr data otherwise
+			zoneData.HasHeart = true
+			zoneData.Heart[heartZones.Index(float64(val))]++
+		}
+	}
+	return
+}
+
+//whether stored time in zone was split by other zone models or boundaries (or smoothing) than the user's current ones
+func Stale(zoneData types.Zones, user types.UserSettings) bool {
+	powerZones := Power(user, user.Ftp)
+	heartZones := Heart(user, user.Thr)
+	return zoneData.PowerModel != powerZones.Model || zoneData.PowerBounds != powerZones.Bounds || len(zoneData.Power) != len(powerZones.Zones) ||
+		zoneData.HeartModel != heartZones.Model || zoneData.HeartBounds != heartZones.Bounds || len(zoneData.Heart) != len(heartZones.Zones) ||
+		zoneData.Smoothing != Smoothing(user)
+}
+
+//an activity's stored time in zone, only re-splitting the time series (fetched by load) where it was processed with other zone settings
+func ForActivity(summary types.Metrics, user types.UserSettings, load func() (powerSeries, heartSeries []int, ftp, thr int)) types.Zones {
+	if !Stale(summary.TimeInZone, user) {
+		return summary.TimeInZone
+	}
+	powerSeries, heartSeries, ftp, thr := load()
+	return TimeInZone(powerSeries, heartSeries, user, ftp, thr)
+}
+
+//add one activity's (or lap's) time in zone to a running total
+func Add(total *types.Zones, zoneData types.Zones) {
+	for i := range zoneData.Power {
+		if i < len(total.Power) {
+			total.Power[i] += zoneData.Power[i]
+		}
+	}
+	for i := range zoneData.Heart {
+		if i < len(total.Heart) {
+			total.Heart[i] += zoneData.Heart[i]
+		}
+	}
+	total.HasPower = total.HasPower || zoneData.HasPower
+	total.HasHeart = total.HasHeart || zoneData.HasHeart
+}
+
+//an empty total for the user's current zone models
+func Empty(user types.UserSettings) (zoneData types.Zones) {
+	powerZones := Power(user, user.Ftp)
+	heartZones := Heart(user, user.Thr)
+	zoneData.PowerModel = powerZones.Model
+	zoneData.HeartModel = heartZones.Model
+	zoneData.PowerBounds = powerZones.Bounds
+	zoneData.HeartBounds = heartZones.Bounds
+	zoneData.Smoothing = Smoothing(user)
+	zoneData.Power = powerZones.Counts()
+	zoneData.Heart = heartZones.Counts()
+	return
+}
+
+//the user's power smoothing window (seconds)
+func Smoothing(user types.UserSettings) int {
+	if user.SampleSize < 1 {
+		return 1
+	}
+	return user.SampleSize
+}
+
+//rolling average of a series over the window - one value per sample, averaging over fewer samples until the window is full
+func Smooth(series []int, window int) []float64 {
+	smoothed := make([]float64, len(series))
+	var sum int
+	for i, val := range series {
+		sum += val
+		if i >= window {
+			sum -= series[i-window]
+		}
+		count := i + 1
+		if count > window {
+			count = window
+		}
+		smoothed[i] = float64(sum) / float64(count)
+	}
+	return smoothed
+}
+
+//user's max heart rate, estimated from age or threshold heart rate where not set
+func MaxHr(user types.UserSettings) int {
+	if user.Mhr > 0 {
+		return user.Mhr
+	}
+	if user.Age > 0 {
+		return 220 - user.Age
+	}
+	return int(float64(user.Thr) * 1.06)
+}
+
+//index of the zone a value falls in
+func (s Set) Index(val float64) int {
+	for i, upper := range s.upper {
+		if val <= upper {
+			return i
+		}
+	}
+	return len(s.upper)
+}
+
+//a zero count for each zone
+func (s Set) Counts() []int {
+	return make([]int, len(s.Zones))
+}
+
+//look up the user's model, falling back to the default where it's unknown or can't be used for this data
+func choose(chosen types.ZoneModel, fallback string, power bool) (string, Model) {
+	model, ok := Models[chosen.Model]
+	if !ok || (power && !model.Power) || (!power && !model.Heart) {
+		return fallback, Models[fallback]
+	}
+	if power && len(model.PowerBounds) > 0 {
+		model.Bounds = model.PowerBounds
+	}
+	if chosen.Model == Custom {
+		if len(chosen.Custom) == 0 {
+			return fallback, Models[fallback]
+		}
+		model.Bounds = make([]float64, 0)
+		model.Names = make([]string, 0)
+		for i, percent := range chosen.Custom {
+			model.Bounds = append(model.Bounds, percent/100)
+			model.Names = append(model.Names, "Zone "+strconv.Itoa(i+1))
+		}
+		model.Names = append(model.Names, "Zone "+strconv.Itoa(len(chosen.Custom)+1))
+	}
+	return chosen.Model, model
+}
+
+//a model's boundaries and any reference values that don't come from the activity, as a key
+func bounds(model Model, ref, rest float64) string {
+	return fmt.Sprintf("%v@%g/%g", model.Bounds, ref, rest)
+}
+
+//work out the absolute zone boundaries
+func resolve(name string, model Model, ref, rest float64) (s Set) {
+	s.Model = name
+	for i, bound := range model.Bounds {
+		s.upper = append(s.upper, rest+bound*(ref-rest))
+		zone := types.Zone{Name: model.Names[i], High: int(s.upper[i])}
+		if i > 0 {
+			zone.Low = s.Zones[i-1].High
+		}
+		s.Zones = append(s.Zones, zone)
+	}
+	top := types.Zone{Name: model.Names[len(model.Bounds)]}
+	if len(s.Zones) > 0 {
+		top.Low = s.Zones[len(s.Zones)-1].High
+	}
+	s.Zones = append(s.Zones, top)
+	for i := range s.Zones {
+		if i < len(model.Descriptions) {
+			s.Zones[i].Description = model.Descriptions[i]
+		}
+	}
+	return
+}